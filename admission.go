@@ -0,0 +1,133 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// metadataList is a flag value for labels or annotations in the form
+// "key=value". It can be given multiple times, but unlike stringList is not
+// split at commas, which may appear in annotation values.
+type metadataList []string
+
+func (l *metadataList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *metadataList) Set(value string) error {
+	key, _, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("%q is not in the form \"key=value\"", value)
+	}
+	*l = append(*l, value)
+	return nil
+}
+
+// helmStorageLabels are the labels that the ConfigMaps and Secrets drivers
+// set on the storage objects they create.
+var helmStorageLabels = []string{"name", "owner", "status", "version", "createdAt", "modifiedAt"}
+
+// parseTargetMetadata validates -target-label and -target-annotation and
+// returns them as maps. The labels that Helm uses to find and describe
+// revisions cannot be overridden.
+func parseTargetMetadata(labels metadataList, annotations metadataList) (map[string]string, map[string]string, error) {
+	labelMap := make(map[string]string, len(labels))
+	for _, label := range labels {
+		key, value, _ := strings.Cut(label, "=")
+		if slices.Contains(helmStorageLabels, key) {
+			return nil, nil, fmt.Errorf("invalid -target-label %q: the %s label is set by Helm", label, key)
+		}
+		errs := append(validation.IsQualifiedName(key), validation.IsValidLabelValue(value)...)
+		if len(errs) > 0 {
+			return nil, nil, fmt.Errorf("invalid -target-label %q: %s", label, strings.Join(errs, ", "))
+		}
+		labelMap[key] = value
+	}
+	annotationMap := make(map[string]string, len(annotations))
+	for _, annotation := range annotations {
+		key, value, _ := strings.Cut(annotation, "=")
+		errs := validation.IsQualifiedName(key)
+		if len(errs) > 0 {
+			return nil, nil, fmt.Errorf("invalid -target-annotation %q: %s", annotation, strings.Join(errs, ", "))
+		}
+		annotationMap[key] = value
+	}
+	return labelMap, annotationMap, nil
+}
+
+// describeTargetMetadata lists the keys of -target-label and
+// -target-annotation for the message logged at the start of a run, so that
+// it is never a surprise that created objects carry them.
+func describeTargetMetadata() string {
+	var parts []string
+	if len(targetLabelMap) > 0 {
+		parts = append(parts, "labels "+strings.Join(slices.Sorted(maps.Keys(targetLabelMap)), ", "))
+	}
+	if len(targetAnnotationMap) > 0 {
+		parts = append(parts, "annotations "+strings.Join(slices.Sorted(maps.Keys(targetAnnotationMap)), ", "))
+	}
+	return strings.Join(parts, " and ")
+}
+
+// errAdmissionDenied marks create errors caused by an admission webhook or a
+// validating admission policy rejecting the storage object, so that they can
+// be reported apart from other failures.
+var errAdmissionDenied = errors.New("rejected by admission control")
+
+// admissionError wraps err with errAdmissionDenied if it is an admission
+// rejection. Admission rejections have no reason of their own: webhooks
+// return whatever status code they choose, so they are recognized by the
+// message the API server puts in front of the webhook's own.
+func admissionError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var status apierrors.APIStatus
+	if !errors.As(err, &status) {
+		return err
+	}
+	message := status.Status().Message
+	if strings.Contains(message, "admission webhook") && strings.Contains(message, "denied the request") ||
+		strings.Contains(message, "ValidatingAdmissionPolicy") && strings.Contains(message, "denied request") {
+		hint := ""
+		if len(targetLabelMap) == 0 && len(targetAnnotationMap) == 0 {
+			hint = ", -target-label and -target-annotation can add the metadata it requires"
+		}
+		return fmt.Errorf("%w%s: %w", errAdmissionDenied, hint, err)
+	}
+	return err
+}
+
+// createOperation returns the operation under which a failure to create a
+// revision in the target is reported.
+func createOperation(err error) string {
+	if errors.Is(err, errAdmissionDenied) {
+		return "admission"
+	}
+	return "create"
+}
@@ -0,0 +1,81 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"helm.sh/helm/v3/pkg/release"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kblabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/metadata"
+)
+
+// filterByAnnotations removes the releases whose latest revision is stored in
+// an object whose annotations do not match -annotation-selector. Helm drops
+// the annotations of storage objects when decoding a release, so they are read
+// from the objects in the namespace, or in all namespaces if it is empty.
+func (m *Migrator) filterByAnnotations(releases []*release.Release, namespace string) ([]*release.Release, error) {
+	if annotationMatcher == nil || len(releases) == 0 {
+		return releases, nil
+	}
+	annotations, err := m.sourceAnnotations(namespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read annotations for -annotation-selector: %w", err)
+	}
+	var selected []*release.Release
+	for _, rls := range releases {
+		key := rls.Namespace + "/" + releaseKey(rls.Name, rls.Version)
+		if annotationMatcher.Matches(kblabels.Set(annotations[key])) {
+			selected = append(selected, rls)
+		}
+	}
+	if skipped := len(releases) - len(selected); skipped > 0 {
+		logf("skipping %d of %d releases whose storage objects do not match -annotation-selector %s", skipped, len(releases), annotationMatcher)
+	}
+	return selected, nil
+}
+
+// sourceAnnotations returns the annotations of the release storage objects in
+// a namespace of the source, keyed by namespace and object name. Only their
+// metadata is listed, not the release payloads.
+func (m *Migrator) sourceAnnotations(namespace string) (map[string]map[string]string, error) {
+	resource, err := driverResource(m.actionCfg.Releases.Name())
+	if err != nil || m.restConfig == nil {
+		return nil, fmt.Errorf("the %s driver has no storage annotations", m.actionCfg.Releases.Name())
+	}
+	client, err := metadata.NewForConfig(m.restConfig)
+	if err != nil {
+		return nil, err
+	}
+	gvr := schema.GroupVersionResource{Version: "v1", Resource: resource}
+	opts := metav1.ListOptions{LabelSelector: kblabels.Set{sourceLabelKeys.Owner: owner}.String()}
+	list, err := client.Resource(gvr).Namespace(namespace).List(context.Background(), opts)
+	if err != nil {
+		return nil, err
+	}
+	annotations := make(map[string]map[string]string)
+	for _, item := range list.Items {
+		annotations[item.Namespace+"/"+item.Name] = item.Annotations
+	}
+	return annotations, nil
+}
@@ -0,0 +1,71 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"testing"
+
+	"helm.sh/helm/v3/pkg/release"
+	corev1 "k8s.io/api/core/v1"
+	kblabels "k8s.io/apimachinery/pkg/labels"
+)
+
+func TestFilterByAnnotations(t *testing.T) {
+	useFlags(t)
+	oldMatcher := annotationMatcher
+	defer func() { annotationMatcher = oldMatcher }()
+	var err error
+	annotationMatcher, err = kblabels.Parse("team=a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api := &fakeConfigMaps{configMaps: make(map[string]*corev1.ConfigMap)}
+	var releases []*release.Release
+	for name, team := range map[string]string{"app": "a", "db": "b"} {
+		for _, namespace := range []string{"x", "y"} {
+			rls := testRelease(name, namespace, 1, release.StatusDeployed)
+			cm := releaseConfigMap(t, rls)
+			cm.Annotations = map[string]string{"team": team}
+			api.put(cm)
+			releases = append(releases, rls)
+		}
+	}
+	m := fakeMigrator(t, api)
+
+	// the releases of y are not in the objects listed for namespace x
+	for namespace, want := range map[string]int{"x": 1, "": 2} {
+		selected, err := m.filterByAnnotations(releases, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(selected) != want {
+			t.Errorf("selected %d releases in namespace %q, want %d", len(selected), namespace, want)
+		}
+		for _, rls := range selected {
+			if rls.Name != "app" {
+				t.Errorf("selected release %s/%s, want only app", rls.Namespace, rls.Name)
+			}
+		}
+	}
+	if api.lists != 0 {
+		t.Errorf("listed the full storage objects %d times, want only their metadata", api.lists)
+	}
+}
@@ -0,0 +1,161 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+)
+
+const (
+	fieldManager = "helm-migrate-release"
+
+	// defaultSecretType is the type the Helm Secrets driver gives to the
+	// Secrets it creates.
+	defaultSecretType = "helm.sh/release.v1"
+)
+
+// createRelease stores a revision in the target driver. With -server-side,
+// the storage object is written via server-side apply instead of the Helm
+// driver's client-side create. Secrets with a custom -secret-type are created
+// by writeRelease as well, because the Helm driver hardcodes the type and the
+// type of an existing Secret cannot be changed. The same goes for
+// -target-label and -target-annotation, which have to be on the object when
+// it is created to satisfy admission webhooks. Rejections by admission
+// control are wrapped with errAdmissionDenied. The revision is already
+// relocated to the target namespace, so -preserve-timestamps needs the source
+// namespace separately.
+func (m *Migrator) createRelease(helmStorage *storage.Storage, namespace string, sourceNS string, rls *release.Release) error {
+	err := validateStorageName(rls)
+	if err != nil {
+		return err
+	}
+	customType := helmStorage.Name() == driver.SecretsDriverName && secretType != defaultSecretType
+	customMetadata := len(targetLabelMap) > 0 || len(targetAnnotationMap) > 0
+	if serverSide || customType || customMetadata {
+		err = m.writeRelease(helmStorage, namespace, rls)
+	} else {
+		err = helmStorage.Create(rls)
+	}
+	if err == nil && preserveTimestamps {
+		m.restoreTimestamps(helmStorage.Name(), namespace, sourceNS, rls)
+	}
+	return admissionError(err)
+}
+
+// writeRelease creates the storage object of a revision without the Helm
+// driver, owned by the helm-migrate-release field manager. With -server-side
+// it is written via server-side apply, otherwise it is created. Like the Helm
+// drivers, it refuses to overwrite an existing revision: apply is an upsert,
+// so it is only used once the revision is known to be absent, and a create
+// fails if the revision was written concurrently.
+func (m *Migrator) writeRelease(helmStorage *storage.Storage, namespace string, rls *release.Release) error {
+	_, err := helmStorage.Get(rls.Name, rls.Version)
+	if err == nil {
+		return driver.ErrReleaseExists
+	}
+	if !errors.Is(err, driver.ErrReleaseNotFound) {
+		return err
+	}
+	data, err := encodeRelease(rls)
+	if err != nil {
+		return fmt.Errorf("failed to encode release %s: %w", rls.Name, err)
+	}
+	key := targetStoragePrefix + releaseKey(rls.Name, rls.Version)
+	labels := storageLabels(rls)
+	for k, v := range targetLabelMap {
+		labels[k] = v
+	}
+	switch helmStorage.Name() {
+	case driver.ConfigMapsDriverName:
+		configMaps := m.targetClientset.CoreV1().ConfigMaps(namespace)
+		if serverSide {
+			cm := corev1ac.ConfigMap(key, namespace).
+				WithLabels(labels).
+				WithAnnotations(targetAnnotationMap).
+				WithData(map[string]string{"release": data})
+			_, err = configMaps.Apply(context.Background(), cm, metav1.ApplyOptions{FieldManager: fieldManager})
+		} else {
+			cm := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: key, Labels: labels, Annotations: targetAnnotationMap},
+				Data:       map[string]string{"release": data},
+			}
+			_, err = configMaps.Create(context.Background(), cm, metav1.CreateOptions{FieldManager: fieldManager})
+		}
+	case driver.SecretsDriverName:
+		secrets := m.targetClientset.CoreV1().Secrets(namespace)
+		if serverSide {
+			secret := corev1ac.Secret(key, namespace).
+				WithLabels(labels).
+				WithAnnotations(targetAnnotationMap).
+				WithType(corev1.SecretType(secretType)).
+				WithData(map[string][]byte{"release": []byte(data)})
+			_, err = secrets.Apply(context.Background(), secret, metav1.ApplyOptions{FieldManager: fieldManager})
+		} else {
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: key, Labels: labels, Annotations: targetAnnotationMap},
+				Type:       corev1.SecretType(secretType),
+				Data:       map[string][]byte{"release": []byte(data)},
+			}
+			_, err = secrets.Create(context.Background(), secret, metav1.CreateOptions{FieldManager: fieldManager})
+		}
+	default:
+		return fmt.Errorf("storage objects cannot be written directly for the %s driver", helmStorage.Name())
+	}
+	if apierrors.IsAlreadyExists(err) {
+		return driver.ErrReleaseExists
+	}
+	return err
+}
+
+// prefixedDriver prepends -target-storage-prefix to the storage keys of the
+// target driver. Helm itself does not know about the prefix: it still finds
+// prefixed revisions when listing a release's history, but fails to get a
+// specific revision by its key, e.g. for helm rollback. The prefix is thus only
+// useful for custom storage layouts whose consumers apply the same prefix.
+type prefixedDriver struct {
+	driver.Driver
+	prefix string
+}
+
+func (d *prefixedDriver) Create(key string, rls *release.Release) error {
+	return d.Driver.Create(d.prefix+key, rls)
+}
+
+func (d *prefixedDriver) Update(key string, rls *release.Release) error {
+	return d.Driver.Update(d.prefix+key, rls)
+}
+
+func (d *prefixedDriver) Delete(key string) (*release.Release, error) {
+	return d.Driver.Delete(d.prefix + key)
+}
+
+func (d *prefixedDriver) Get(key string) (*release.Release, error) {
+	return d.Driver.Get(d.prefix + key)
+}
@@ -0,0 +1,139 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+func TestWriteRelease(t *testing.T) {
+	rls := testRelease("app", "b", 1, release.StatusDeployed)
+	existing := func() *corev1.ConfigMap {
+		cm := releaseConfigMap(t, rls)
+		cm.Labels["existing"] = "true"
+		return cm
+	}
+	key := configMapKey("b", releaseKey(rls.Name, rls.Version))
+	isGet := func(r *http.Request) bool {
+		return r.Method == http.MethodGet && r.URL.Path == "/api/v1/namespaces/b/configmaps/"+releaseKey(rls.Name, rls.Version)
+	}
+	tests := []struct {
+		name          string
+		existing      bool
+		intercept     func(f *fakeConfigMaps, r *http.Request) *apierrors.StatusError
+		wantErr       bool
+		wantExists    bool
+		wantCreatedBy []string
+	}{
+		{name: "absent", wantCreatedBy: []string{fieldManager}},
+		{name: "exists", existing: true, wantErr: true, wantExists: true},
+		{
+			name: "reading the target fails",
+			intercept: func(f *fakeConfigMaps, r *http.Request) *apierrors.StatusError {
+				if isGet(r) {
+					return apierrors.NewInternalError(errors.New("etcd is unavailable"))
+				}
+				return nil
+			},
+			wantErr: true,
+		},
+		{
+			name: "created concurrently",
+			intercept: func(f *fakeConfigMaps, r *http.Request) *apierrors.StatusError {
+				if isGet(r) && f.configMaps[key] == nil {
+					f.put(existing())
+					return apierrors.NewNotFound(corev1.Resource("configmaps"), releaseKey(rls.Name, rls.Version))
+				}
+				return nil
+			},
+			wantErr:    true,
+			wantExists: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFlags(t)
+			api := &fakeConfigMaps{configMaps: make(map[string]*corev1.ConfigMap), intercept: tt.intercept}
+			if tt.existing {
+				api.put(existing())
+			}
+			m := fakeMigrator(t, api)
+			helmStorage := storage.Init(driver.NewConfigMaps(m.targetClientset.CoreV1().ConfigMaps("b")))
+
+			err := m.writeRelease(helmStorage, "b", rls)
+			if (err != nil) != tt.wantErr || errors.Is(err, driver.ErrReleaseExists) != tt.wantExists {
+				t.Fatalf("writeRelease returned %v, want error: %t, release exists: %t", err, tt.wantErr, tt.wantExists)
+			}
+			if !slices.Equal(api.createdBy, tt.wantCreatedBy) {
+				t.Errorf("created objects with field managers %v, want %v", api.createdBy, tt.wantCreatedBy)
+			}
+			if cm := api.configMaps[key]; tt.wantExists && cm.Labels["existing"] != "true" {
+				t.Error("the existing revision was overwritten")
+			}
+		})
+	}
+}
+
+func TestStorageLabels(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   map[string]string
+	}{
+		{
+			name:   "read by Get",
+			labels: nil,
+			want:   map[string]string{"name": "app", "owner": "helm", "status": "deployed", "version": "1"},
+		},
+		{
+			name:   "read by label query",
+			labels: map[string]string{"name": "app", "owner": "helm", "status": "superseded", "version": "1", "createdAt": "1600000000", "modifiedAt": "1600000100", "team": "a"},
+			want:   map[string]string{"name": "app", "owner": "helm", "status": "deployed", "version": "1", "team": "a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rls := testRelease("app", "default", 1, release.StatusDeployed)
+			rls.Labels = tt.labels
+			before := time.Now().Unix()
+			labels := storageLabels(rls)
+			createdAt, err := strconv.ParseInt(labels["createdAt"], 10, 64)
+			if err != nil || createdAt < before {
+				t.Errorf("createdAt is %q, want the current time", labels["createdAt"])
+			}
+			delete(labels, "createdAt")
+			if !maps.Equal(labels, tt.want) {
+				t.Errorf("labels are %v, want %v", labels, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+)
+
+// With -from auto, releases are read from whichever of the ConfigMaps and
+// Secrets drivers is not the -to driver, regardless of $HELM_DRIVER. Both
+// drivers are probed for each release: a release stored only in the target
+// is reported as skipped, and a release stored in both is skipped until the
+// split brain is resolved with repair-duplicates.
+
+// autoSourceDriver returns the Helm driver name of the source for -from auto.
+func autoSourceDriver() (string, error) {
+	switch to {
+	case "configmap", "configmaps":
+		return "secret", nil
+	case "secret", "secrets":
+		return "configmap", nil
+	default:
+		return "", errors.New("-from auto requires -to configmap or -to secret")
+	}
+}
+
+// autoSkipReason returns why a release must be skipped with -from auto, or an
+// empty string if it is stored in the source driver only.
+func (m *Migrator) autoSkipReason(helmStorage *storage.Storage, releaseName string, sourceRevisions int) (string, error) {
+	existing, err := helmStorage.History(releaseName)
+	if errors.Is(err, driver.ErrReleaseNotFound) || (err == nil && len(existing) == 0) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if sourceRevisions == 0 {
+		return fmt.Sprintf("skipping because the release is already stored in %s only", helmStorage.Name()), nil
+	}
+	return fmt.Sprintf("skipping because the release is stored in both %s and %s, resolve this with repair-duplicates first", helmStorage.Name(), m.actionCfg.Releases.Name()), nil
+}
+
+// unionTargetReleases adds the releases that are only stored in the target
+// driver to the releases listed from the source, so that -from auto reports
+// every release found by either driver. An empty namespace stands for all
+// namespaces.
+func (m *Migrator) unionTargetReleases(releases []*release.Release, namespace string) ([]*release.Release, error) {
+	if from != "auto" {
+		return releases, nil
+	}
+	targetReleases, err := m.listTargetReleases(namespace)
+	if err != nil {
+		return nil, err
+	}
+	inSource := make(map[string]bool, len(releases))
+	for _, rls := range releases {
+		inSource[rls.Namespace+"/"+rls.Name] = true
+	}
+	for _, rls := range targetReleases {
+		if !inSource[rls.Namespace+"/"+rls.Name] {
+			releases = append(releases, rls)
+		}
+	}
+	return releases, nil
+}
@@ -0,0 +1,128 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"context"
+	"slices"
+	"strconv"
+
+	"helm.sh/helm/v3/pkg/release"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kblabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/metadata"
+)
+
+// Helm 3 keeps all state of a release in one storage object per revision.
+// The records of operations that are in progress or were interrupted, e.g.
+// by --atomic rolling back, are revisions with a pending-install,
+// pending-upgrade or pending-rollback status, and are migrated like any other
+// revision. There are no separate lock objects.
+//
+// auxiliaryObjects returns the names of the source storage objects that carry
+// the owner and name labels of a release but are not one of its revisions,
+// e.g. because they were renamed or copied by hand. They are not migrated,
+// but reported so that operators do not miss them. Drivers other than
+// ConfigMaps and Secrets have no such objects.
+//
+// During a pass of migrateReleases, the objects of a namespace are listed
+// once for all of its releases. Otherwise, e.g. for each event of -watch, they
+// are listed again, so that objects created since are found.
+func (m *Migrator) auxiliaryObjects(releaseName string, namespace string) ([]string, error) {
+	if m.restConfig == nil {
+		return nil, nil
+	}
+	m.auxiliaryMutex.Lock()
+	defer m.auxiliaryMutex.Unlock()
+	byRelease, inPass := m.auxiliaryByNamespace[namespace]
+	if byRelease == nil {
+		var err error
+		byRelease, err = m.listAuxiliaryObjects(namespace)
+		if err != nil {
+			return nil, err
+		}
+		if inPass {
+			m.auxiliaryByNamespace[namespace] = byRelease
+		}
+	}
+	return byRelease[releaseName], nil
+}
+
+// beginAuxiliaryPass makes auxiliaryObjects cache the objects of the
+// namespaces of releases until the returned function is called.
+func (m *Migrator) beginAuxiliaryPass(releases []*release.Release) (end func()) {
+	m.auxiliaryMutex.Lock()
+	defer m.auxiliaryMutex.Unlock()
+	if m.auxiliaryByNamespace == nil {
+		m.auxiliaryByNamespace = make(map[string]map[string][]string)
+	}
+	var namespaces []string
+	for _, rls := range releases {
+		if _, ok := m.auxiliaryByNamespace[rls.Namespace]; !ok {
+			m.auxiliaryByNamespace[rls.Namespace] = nil
+			namespaces = append(namespaces, rls.Namespace)
+		}
+	}
+	return func() {
+		m.auxiliaryMutex.Lock()
+		defer m.auxiliaryMutex.Unlock()
+		for _, namespace := range namespaces {
+			delete(m.auxiliaryByNamespace, namespace)
+		}
+	}
+}
+
+// listAuxiliaryObjects lists the metadata of the storage objects of all
+// releases in a namespace at once, instead of once for each release, and
+// returns the names of the auxiliary ones by release name.
+func (m *Migrator) listAuxiliaryObjects(namespace string) (map[string][]string, error) {
+	resource, err := driverResource(m.actionCfg.Releases.Name())
+	if err != nil {
+		return nil, nil
+	}
+	client, err := metadata.NewForConfig(m.restConfig)
+	if err != nil {
+		return nil, err
+	}
+	gvr := schema.GroupVersionResource{Version: "v1", Resource: resource}
+	opts := metav1.ListOptions{
+		LabelSelector: kblabels.Set{sourceLabelKeys.Owner: owner}.String(),
+	}
+	list, err := client.Resource(gvr).Namespace(namespace).List(context.Background(), opts)
+	if err != nil {
+		return nil, err
+	}
+	auxiliary := make(map[string][]string)
+	for _, item := range list.Items {
+		releaseName, ok := item.Labels[sourceLabelKeys.Name]
+		if !ok {
+			continue
+		}
+		version, err := strconv.Atoi(item.Labels[sourceLabelKeys.Version])
+		if err != nil || item.Name != releaseKey(releaseName, version) {
+			auxiliary[releaseName] = append(auxiliary[releaseName], item.Name)
+		}
+	}
+	for _, names := range auxiliary {
+		slices.Sort(names)
+	}
+	return auxiliary, nil
+}
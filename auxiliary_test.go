@@ -0,0 +1,81 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/release"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestAuxiliaryObjects(t *testing.T) {
+	useFlags(t)
+	api := &fakeConfigMaps{configMaps: make(map[string]*corev1.ConfigMap)}
+	for _, rls := range []*release.Release{
+		testRelease("app", "default", 1, release.StatusSuperseded),
+		testRelease("app", "default", 2, release.StatusDeployed),
+		testRelease("db", "default", 1, release.StatusDeployed),
+	} {
+		api.put(releaseConfigMap(t, rls))
+	}
+	backup := releaseConfigMap(t, testRelease("app", "default", 1, release.StatusSuperseded))
+	backup.Name = "sh.helm.release.v1.app.v1-backup"
+	api.put(backup)
+	m := fakeMigrator(t, api)
+	end := m.beginAuxiliaryPass([]*release.Release{testRelease("app", "default", 2, release.StatusDeployed)})
+
+	tests := []struct {
+		release string
+		want    []string
+	}{
+		{release: "app", want: []string{backup.Name}},
+		{release: "db", want: nil},
+		{release: "missing", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.release, func(t *testing.T) {
+			got, err := m.auxiliaryObjects(tt.release, "default")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("auxiliary objects are %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if api.metadataLists != 1 || api.lists != 0 {
+		t.Errorf("listed the metadata of the namespace %d times and the full objects %d times, want once and never", api.metadataLists, api.lists)
+	}
+
+	// after the pass, objects created since are found
+	end()
+	copied := releaseConfigMap(t, testRelease("db", "default", 1, release.StatusDeployed))
+	copied.Name = "sh.helm.release.v1.db.v1-copy"
+	api.put(copied)
+	got, err := m.auxiliaryObjects("db", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{copied.Name}; !slices.Equal(got, want) {
+		t.Errorf("auxiliary objects after the pass are %v, want %v", got, want)
+	}
+}
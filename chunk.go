@@ -0,0 +1,135 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
+	"strconv"
+
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kblabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/metadata"
+)
+
+// chunkedHistory returns the versions of a release selected by -since-version
+// and -max in ascending order, together with its latest revision. Only the
+// metadata of the source storage objects is listed, so that the payloads of a
+// very long history are never loaded at once.
+func (m *Migrator) chunkedHistory(releaseName string, sourceNS string) ([]int, []*release.Release, error) {
+	resource, err := driverResource(m.actionCfg.Releases.Name())
+	if err != nil || m.restConfig == nil {
+		return nil, nil, fmt.Errorf("-chunk-history requires ConfigMaps or Secrets as the source driver, got %s", m.actionCfg.Releases.Name())
+	}
+	client, err := metadata.NewForConfig(m.restConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	selector := kblabels.Set{sourceLabelKeys.Name: releaseName, sourceLabelKeys.Owner: owner}
+	for k, v := range sourceLabels {
+		selector[k] = v
+	}
+	gvr := schema.GroupVersionResource{Version: "v1", Resource: resource}
+	list, err := client.Resource(gvr).Namespace(sourceNS).List(context.Background(), metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, nil, err
+	}
+	var versions []int
+	for _, item := range list.Items {
+		version, err := strconv.Atoi(item.Labels[sourceLabelKeys.Version])
+		if err != nil {
+			warnf("ignoring release storage object %s/%s with invalid version label %q", sourceNS, item.Name, item.Labels[sourceLabelKeys.Version])
+			continue
+		}
+		if version > sinceVersion {
+			versions = append(versions, version)
+		}
+	}
+	slices.Sort(versions)
+	if maxHist > 0 && len(versions) > maxHist {
+		versions = versions[len(versions)-maxHist:]
+	}
+	if len(versions) == 0 {
+		return nil, nil, nil
+	}
+	latest, err := m.sourceStorage(sourceNS).Get(releaseName, versions[len(versions)-1])
+	if err != nil {
+		return nil, nil, err
+	}
+	normalizeLabels(latest)
+	return versions, []*release.Release{latest}, nil
+}
+
+// historyChunks yields the revisions to migrate in -history-order. Without
+// -chunk-history, that is hist as a whole. Otherwise each chunk of versions is
+// only fetched from the source once the previous chunk was processed.
+// Versions that disappeared in the meantime are skipped.
+func (m *Migrator) historyChunks(releaseName string, sourceNS string, hist []*release.Release, versions []int) iter.Seq2[[]*release.Release, error] {
+	return func(yield func([]*release.Release, error) bool) {
+		if versions == nil {
+			yield(orderedHistory(hist), nil)
+			return
+		}
+		if historyOrder == "descending" {
+			versions = slices.Clone(versions)
+			slices.Reverse(versions)
+		}
+		source := m.sourceStorage(sourceNS)
+		for chunkVersions := range slices.Chunk(versions, chunkHistory) {
+			chunk := make([]*release.Release, 0, len(chunkVersions))
+			for _, version := range chunkVersions {
+				rls, err := source.Get(releaseName, version)
+				if errors.Is(err, driver.ErrReleaseNotFound) {
+					continue
+				}
+				if err != nil {
+					yield(nil, err)
+					return
+				}
+				normalizeLabels(rls)
+				chunk = append(chunk, rls)
+			}
+			if !yield(chunk, nil) {
+				return
+			}
+		}
+	}
+}
+
+// orderedHistory returns a history sorted by version in the order in which
+// its revisions are created in the target. Helm finds the latest revision by
+// its version, not by when it was stored, but with the default ascending
+// order an interrupted run never leaves a target in which a revision exists
+// without its predecessors. Descending order migrates the latest revision
+// first instead, which helm list then shows in the target right away.
+func orderedHistory(hist []*release.Release) []*release.Release {
+	if historyOrder != "descending" {
+		return hist
+	}
+	reversed := slices.Clone(hist)
+	slices.Reverse(reversed)
+	return reversed
+}
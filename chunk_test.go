@@ -0,0 +1,108 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/release"
+)
+
+func TestHistoryOrder(t *testing.T) {
+	tests := []struct {
+		name         string
+		order        string
+		statuses     []release.Status
+		wantOrder    []int
+		wantDeployed int
+		wantListed   int
+	}{
+		{
+			name:         "ascending",
+			order:        "ascending",
+			statuses:     []release.Status{release.StatusSuperseded, release.StatusSuperseded, release.StatusDeployed},
+			wantOrder:    []int{1, 2, 3},
+			wantDeployed: 3,
+			wantListed:   3,
+		},
+		{
+			name:         "descending",
+			order:        "descending",
+			statuses:     []release.Status{release.StatusSuperseded, release.StatusSuperseded, release.StatusDeployed},
+			wantOrder:    []int{3, 2, 1},
+			wantDeployed: 3,
+			wantListed:   3,
+		},
+		{
+			name:         "descending after a failed upgrade",
+			order:        "descending",
+			statuses:     []release.Status{release.StatusSuperseded, release.StatusDeployed, release.StatusFailed},
+			wantOrder:    []int{3, 2, 1},
+			wantDeployed: 2,
+			wantListed:   3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFlags(t)
+			historyOrder = tt.order
+			var seeded []*release.Release
+			for i, status := range tt.statuses {
+				seeded = append(seeded, testRelease("app", "default", i+1, status))
+			}
+
+			var order []int
+			for _, rls := range orderedHistory(seeded) {
+				order = append(order, rls.Version)
+			}
+			if !slices.Equal(order, tt.wantOrder) {
+				t.Errorf("revisions are created in order %v, want %v", order, tt.wantOrder)
+			}
+
+			m, err := newMemoryMigrator("default", seeded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = m.migrateRelease("app", "default")
+			if err != nil {
+				t.Fatal(err)
+			}
+			target, err := m.targetStorage("default")
+			if err != nil {
+				t.Fatal(err)
+			}
+			deployed, err := target.Deployed("app")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if deployed.Version != tt.wantDeployed {
+				t.Errorf("version %d is deployed in the target, want %d", deployed.Version, tt.wantDeployed)
+			}
+			listed, err := m.listTargetReleases("default")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(listed) != 1 || listed[0].Version != tt.wantListed {
+				t.Errorf("helm list shows %v in the target, want version %d", listed, tt.wantListed)
+			}
+		})
+	}
+}
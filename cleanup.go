@@ -0,0 +1,95 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"helm.sh/helm/v3/pkg/storage/driver"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kblabels "k8s.io/apimachinery/pkg/labels"
+)
+
+// cleanupOrphans deletes Helm-owned release ConfigMaps that were left behind
+// in a namespace after its releases moved to the target driver, e.g. old
+// revisions outside of -max. Only ConfigMaps whose revision exists in the
+// target with the same content are deleted, so revisions that failed to
+// migrate or were never selected for migration are kept. Namespaces in which
+// a release failed to migrate are not cleaned up at all.
+func (m *Migrator) cleanupOrphans(namespace string) error {
+	if m.actionCfg.Releases.Name() != driver.ConfigMapsDriverName {
+		return fmt.Errorf("-cleanup-orphans requires ConfigMaps as the source driver, got %s", m.actionCfg.Releases.Name())
+	}
+	if m.namespaceFailed(namespace) {
+		warnf("not cleaning up orphaned release ConfigMaps in namespace %s, because releases in it failed to migrate", namespace)
+		return nil
+	}
+	targetNS := targetNamespaceFor(namespace)
+	helmStorage, err := m.targetStorage(targetNS)
+	if err != nil {
+		return err
+	}
+	source := m.sourceStorage(namespace)
+	selector := kblabels.Set{sourceLabelKeys.Owner: owner}.AsSelector().String()
+	list, err := m.clientset.CoreV1().ConfigMaps(namespace).List(context.Background(), metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return err
+	}
+	for _, cm := range list.Items {
+		rls, err := source.Driver.Get(cm.Name)
+		if err != nil {
+			warnf("not deleting release ConfigMap %s/%s, it cannot be read: %s", namespace, cm.Name, err)
+			continue
+		}
+		normalizeLabels(rls)
+		state, err := targetState(helmStorage, relocated(rls, targetNS))
+		if err != nil {
+			return err
+		}
+		if state != "identical" {
+			debugf("not deleting release ConfigMap %s/%s, it is %s in target", namespace, cm.Name, state)
+			continue
+		}
+		if dryRun {
+			logf("would delete orphaned release ConfigMap %s/%s", namespace, cm.Name)
+			continue
+		}
+		err = m.clientset.CoreV1().ConfigMaps(namespace).Delete(context.Background(), cm.Name, metav1.DeleteOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to delete orphaned release ConfigMap %s/%s: %w", namespace, cm.Name, err)
+		}
+		logf("deleted orphaned release ConfigMap %s/%s", namespace, cm.Name)
+	}
+	return nil
+}
+
+// namespaceFailed reports whether a release in a namespace of this context
+// failed to migrate so far.
+func (m *Migrator) namespaceFailed(namespace string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, ns := range m.summary.Namespaces {
+		if ns.Context == m.kubeContext && ns.Namespace == namespace && ns.Failed > 0 {
+			return true
+		}
+	}
+	return false
+}
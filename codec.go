@@ -0,0 +1,133 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"helm.sh/helm/v3/pkg/release"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// The Helm storage drivers do not export their encoding helpers, so the
+// functions in this file mirror helm.sh/helm/v3/pkg/storage/driver/util.go.
+
+// releaseKey returns the name of the storage object holding a revision.
+func releaseKey(releaseName string, version int) string {
+	return fmt.Sprintf("sh.helm.release.v1.%s.v%d", releaseName, version)
+}
+
+// validateStorageName checks that the storage object of a revision can be
+// created with its computed name and labels, so that overlong release names
+// are reported before the API server rejects them with a validation error.
+func validateStorageName(rls *release.Release) error {
+	key := targetStoragePrefix + releaseKey(rls.Name, rls.Version)
+	if len(key) > validation.DNS1123SubdomainMaxLength {
+		return fmt.Errorf("storage object name %q has %d characters, more than the allowed %d: the release needs a shorter name", key, len(key), validation.DNS1123SubdomainMaxLength)
+	}
+	if errs := validation.IsValidLabelValue(rls.Name); len(errs) > 0 {
+		return fmt.Errorf("release name %q cannot be used as name label of %q: %s: the release needs a shorter name", rls.Name, key, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// encodeRelease returns the base64 encoded, gzipped JSON representation of a
+// release, as stored by the ConfigMaps and Secrets drivers.
+func encodeRelease(rls *release.Release) (string, error) {
+	b, err := json.Marshal(rls)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	_, err = w.Write(b)
+	if err != nil {
+		return "", err
+	}
+	err = w.Close()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// storageLabels returns the labels the Helm drivers put on the storage object
+// of a release when creating it. Revisions read with a label query carry the
+// timestamp labels of their source object, which are replaced as for a newly
+// created object: createdAt is set to the current time and modifiedAt is
+// dropped.
+func storageLabels(rls *release.Release) map[string]string {
+	labels := make(map[string]string, len(rls.Labels)+5)
+	for k, v := range rls.Labels {
+		labels[k] = v
+	}
+	labels["createdAt"] = strconv.Itoa(int(time.Now().Unix()))
+	delete(labels, "modifiedAt")
+	labels["name"] = rls.Name
+	labels["owner"] = "helm"
+	labels["status"] = releaseStatus(rls).String()
+	labels["version"] = strconv.Itoa(rls.Version)
+	return labels
+}
+
+// decodeRelease parses the payload of a release storage object as written by
+// encodeRelease.
+func decodeRelease(data string) (*release.Release, error) {
+	b, err := decodePayload(data)
+	if err != nil {
+		return nil, err
+	}
+	var rls release.Release
+	err = json.Unmarshal(b, &rls)
+	if err != nil {
+		return nil, err
+	}
+	return &rls, nil
+}
+
+// decodePayload undoes the base64 encoding and gzip compression of a storage
+// object's payload. Like Helm, it also accepts uncompressed payloads written
+// before compression was introduced.
+func decodePayload(data string) ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > 3 && bytes.Equal(b[0:3], []byte{0x1f, 0x8b, 0x08}) {
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	}
+	return b, nil
+}
@@ -0,0 +1,144 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"slices"
+	"text/tabwriter"
+
+	"helm.sh/helm/v3/pkg/release"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// largeRecordSize is the encoded size above which a revision is reported as
+// approaching the size limit of a storage object by preflight -count-only.
+// The limit applies to the whole object, so metadata and labels take up the
+// rest.
+const largeRecordSize = maxStorageObjectSize * 9 / 10
+
+// NamespaceCount is the number and total encoded size of the revisions a
+// migration would create from a namespace.
+type NamespaceCount struct {
+	Namespace string `json:"namespace"`
+	Releases  int    `json:"releases"`
+	Records   int    `json:"records"`
+	Size      int    `json:"size"`
+}
+
+// LargeRecord is a revision whose encoded size is close to the size limit of
+// a storage object.
+type LargeRecord struct {
+	Release   string `json:"release"`
+	Namespace string `json:"namespace"`
+	Version   int    `json:"version"`
+	Size      int    `json:"size"`
+}
+
+// CountResult is the outcome of preflight -count-only. It is written as a
+// single JSON object in JSON output mode.
+type CountResult struct {
+	Type         string           `json:"type"`
+	Releases     int              `json:"releases"`
+	Records      int              `json:"records"`
+	Size         int              `json:"size"`
+	Namespaces   []NamespaceCount `json:"namespaces"`
+	LargeRecords []LargeRecord    `json:"large_records,omitempty"`
+}
+
+// countRecords counts the revisions that a migration of the given namespace,
+// or of all namespaces if it is empty, would create in the target, and sums
+// up their encoded size, which is what ends up in etcd. It honors the same
+// flags that select releases and revisions for a migration, e.g. -max,
+// -exclude and, for all namespaces, -namespaces and -namespace-selector, but
+// reads nothing from the target. This is meant for planning a migration from
+// ConfigMaps to Secrets, both of which hold the same encoded payload, against
+// the capacity of etcd.
+func (m *Migrator) countRecords(namespace string) error {
+	all := namespace == metav1.NamespaceAll
+	var releases []*release.Release
+	var err error
+	if all {
+		releases, err = m.listAllReleases()
+		if err == nil {
+			releases, err = m.restrictNamespaces(releases)
+		}
+	} else {
+		releases, err = m.listReleases(false)
+	}
+	if err != nil {
+		return err
+	}
+	releases = skipExcluded(releases)
+	result := CountResult{Type: "count"}
+	byNamespace := make(map[string]*NamespaceCount)
+	for _, rls := range releases {
+		if !all && rls.Namespace != namespace || all && !namespaceSelected(rls.Namespace) {
+			continue
+		}
+		hist, err := m.releaseHistory(rls.Name, rls.Namespace)
+		if err != nil {
+			return fmt.Errorf("failed to read history of release %s/%s: %w", rls.Namespace, rls.Name, err)
+		}
+		count := byNamespace[rls.Namespace]
+		if count == nil {
+			count = &NamespaceCount{Namespace: rls.Namespace}
+			byNamespace[rls.Namespace] = count
+		}
+		count.Releases++
+		for _, revision := range hist {
+			data, err := encodeRelease(revision)
+			if err != nil {
+				return fmt.Errorf("failed to encode release %s/%s version %d: %w", rls.Namespace, rls.Name, revision.Version, err)
+			}
+			count.Records++
+			count.Size += len(data)
+			if len(data) > largeRecordSize {
+				result.LargeRecords = append(result.LargeRecords, LargeRecord{Release: rls.Name, Namespace: rls.Namespace, Version: revision.Version, Size: len(data)})
+			}
+		}
+	}
+	for _, count := range byNamespace {
+		result.Releases += count.Releases
+		result.Records += count.Records
+		result.Size += count.Size
+		result.Namespaces = append(result.Namespaces, *count)
+	}
+	slices.SortFunc(result.Namespaces, func(a, b NamespaceCount) int {
+		return cmp.Compare(a.Namespace, b.Namespace)
+	})
+
+	for _, large := range result.LargeRecords {
+		warnf("release %s/%s version %d is %d bytes, close to the limit of %d bytes per storage object", large.Namespace, large.Release, large.Version, large.Size, maxStorageObjectSize)
+	}
+	if output == "json" {
+		writeJSON(result)
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tRELEASES\tRECORDS\tSIZE")
+	for _, count := range result.Namespaces {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", count.Namespace, count.Releases, count.Records, count.Size)
+	}
+	fmt.Fprintf(w, "total\t%d\t%d\t%d\n", result.Releases, result.Records, result.Size)
+	return w.Flush()
+}
@@ -0,0 +1,72 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/release"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCountRecordsWithNamespaces(t *testing.T) {
+	useFlags(t)
+	oldOutput, oldNamespaces, oldStdout := output, namespaceList, os.Stdout
+	defer func() { output, namespaceList, os.Stdout = oldOutput, oldNamespaces, oldStdout }()
+	output = "json"
+	namespaceList = stringList{"a", "c"}
+
+	api := &fakeConfigMaps{configMaps: make(map[string]*corev1.ConfigMap)}
+	for _, rls := range []*release.Release{
+		testRelease("app", "a", 1, release.StatusSuperseded),
+		testRelease("app", "a", 2, release.StatusDeployed),
+		testRelease("app", "b", 1, release.StatusDeployed),
+		testRelease("db", "c", 1, release.StatusDeployed),
+	} {
+		api.put(releaseConfigMap(t, rls))
+	}
+	m := fakeMigrator(t, api)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = w
+	err = m.countRecords(metav1.NamespaceAll)
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result CountResult
+	err = json.NewDecoder(r).Decode(&result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var namespaces []string
+	for _, count := range result.Namespaces {
+		namespaces = append(namespaces, count.Namespace)
+	}
+	if result.Releases != 2 || result.Records != 3 || len(namespaces) != 2 || namespaces[0] != "a" || namespaces[1] != "c" {
+		t.Errorf("counted %d releases with %d records in namespaces %v, want 2 with 3 in [a c]", result.Releases, result.Records, namespaces)
+	}
+}
@@ -0,0 +1,74 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"helm.sh/helm/v3/pkg/storage/driver"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kblabels "k8s.io/apimachinery/pkg/labels"
+)
+
+// checkPayloads decodes the raw payload of every source storage object of a
+// release and returns one message per record that does not decode. The Helm
+// drivers log and then silently drop such records when listing, so without
+// this check a corrupt revision would just be missing from the history.
+// Drivers other than ConfigMaps and Secrets are not checked.
+func (m *Migrator) checkPayloads(releaseName string, namespace string) ([]string, error) {
+	if m.clientset == nil {
+		return nil, nil
+	}
+	opts := metav1.ListOptions{
+		LabelSelector: kblabels.Set{sourceLabelKeys.Name: releaseName, sourceLabelKeys.Owner: owner}.String(),
+	}
+	payloads := make(map[string]string)
+	switch m.actionCfg.Releases.Name() {
+	case driver.ConfigMapsDriverName:
+		list, err := m.clientset.CoreV1().ConfigMaps(namespace).List(context.Background(), opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, cm := range list.Items {
+			payloads[cm.Name] = cm.Data["release"]
+		}
+	case driver.SecretsDriverName:
+		list, err := m.clientset.CoreV1().Secrets(namespace).List(context.Background(), opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, secret := range list.Items {
+			payloads[secret.Name] = string(secret.Data["release"])
+		}
+	default:
+		return nil, nil
+	}
+	var problems []string
+	for key, payload := range payloads {
+		_, err := decodeRelease(payload)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s does not decode: %s", key, err))
+		}
+	}
+	slices.Sort(problems)
+	return problems, nil
+}
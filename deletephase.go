@@ -0,0 +1,180 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"helm.sh/helm/v3/pkg/release"
+)
+
+// With -delete-phase, the releases of a namespace are only copied at first.
+// Their source revisions are collected by deferDelete and deleted together by
+// runDeletePhase once all releases of the namespace are copied, unless any of
+// them failed. The -post-hook of a release is deferred as well, and only runs
+// once all of its source revisions are deleted.
+
+// deferredHook is a -post-hook run queued for the delete phase.
+type deferredHook struct {
+	Release   string
+	Namespace string
+	Version   int
+}
+
+// deferDelete queues a copied source revision for the delete phase of its
+// namespace.
+func (m *Migrator) deferDelete(namespace string, rls *release.Release) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.deferredDeletes == nil {
+		m.deferredDeletes = make(map[string][]*release.Release)
+	}
+	m.deferredDeletes[namespace] = append(m.deferredDeletes[namespace], rls)
+}
+
+// deferHook queues the -post-hook of a copied release for the delete phase of
+// its source namespace.
+func (m *Migrator) deferHook(namespace string, hook deferredHook) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.deferredHooks == nil {
+		m.deferredHooks = make(map[string][]deferredHook)
+	}
+	m.deferredHooks[namespace] = append(m.deferredHooks[namespace], hook)
+}
+
+// abortDeletePhase makes the delete phase of a namespace keep all source
+// revisions, because one of its releases failed.
+func (m *Migrator) abortDeletePhase(namespace string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.abortedDeletes == nil {
+		m.abortedDeletes = make(map[string]bool)
+	}
+	m.abortedDeletes[namespace] = true
+}
+
+// runDeletePhase verifies that all revisions copied from a namespace are
+// identical in the target, and only then deletes them from the source. Then
+// it runs the post-hooks of the releases whose revisions were all deleted.
+func (m *Migrator) runDeletePhase(namespace string) error {
+	m.mutex.Lock()
+	revisions, hooks, aborted := m.deferredDeletes[namespace], m.deferredHooks[namespace], m.abortedDeletes[namespace]
+	delete(m.deferredDeletes, namespace)
+	delete(m.deferredHooks, namespace)
+	m.mutex.Unlock()
+	if len(revisions) == 0 {
+		return nil
+	}
+	if aborted {
+		warnf("keeping the %d copied revisions in namespace %s in the source, because releases in it failed", len(revisions), namespace)
+		if len(hooks) > 0 {
+			warnf("not running the post-hook for the %d copied releases in namespace %s", len(hooks), namespace)
+		}
+		return nil
+	}
+
+	helmStorage, err := m.targetStorage(targetNamespaceFor(namespace))
+	if err != nil {
+		return err
+	}
+	for _, rls := range revisions {
+		state, err := targetState(helmStorage, rls)
+		if err == nil && state != "identical" {
+			err = fmt.Errorf("version is %s in target", state)
+		}
+		if err != nil {
+			err = fmt.Errorf("keeping the %d copied revisions in namespace %s in the source, release %s version %d failed to verify: %w", len(revisions), namespace, rls.Name, rls.Version, err)
+			m.failDeletePhase(namespace, revisions, err)
+			return err
+		}
+	}
+	infof("verified the %d copied revisions in namespace %s, deleting them from the source", len(revisions), namespace)
+
+	var failures []*release.Release
+	for _, rls := range revisions {
+		err := m.deleteSource(helmStorage, rls.Name, namespace, rls.Version)
+		if err != nil {
+			failures = append(failures, rls)
+			errorf(ErrorRecord{Release: rls.Name, Namespace: namespace, Version: rls.Version, Operation: "delete"}, "failed to delete release %s version %d: %s", rls.Name, rls.Version, err)
+			continue
+		}
+		infof("deleted release %s version %d from source", rls.Name, rls.Version)
+	}
+	var deleteErr error
+	if len(failures) > 0 {
+		deleteErr = fmt.Errorf("failed to delete %d of the %d copied revisions in namespace %s from the source", len(failures), len(revisions), namespace)
+		m.failDeletePhase(namespace, failures, deleteErr)
+	}
+
+	failed := versionsByRelease(failures)
+	hookFailures := 0
+	for _, hook := range hooks {
+		if _, ok := failed[hook.Release]; ok {
+			continue
+		}
+		err := runReleaseHook(hook.Release, hook.Namespace, hook.Version)
+		if err != nil {
+			hookFailures++
+			errorf(ErrorRecord{Release: hook.Release, Namespace: hook.Namespace, Version: hook.Version, Operation: "post-hook"}, "%s", err)
+			m.failReleases(namespace, map[string][]int{hook.Release: nil}, err)
+		}
+	}
+	if deleteErr != nil {
+		return deleteErr
+	}
+	if hookFailures > 0 {
+		return fmt.Errorf("post-hook failed for %d releases in namespace %s", hookFailures, namespace)
+	}
+	return nil
+}
+
+// failDeletePhase reports the releases of the given revisions as failed,
+// after the delete phase of their namespace failed for them. They were
+// reported as copied when they were copied, so that result is counted again
+// as a failure and written once more with the error. This also keeps
+// -cleanup-orphans out of the namespace.
+func (m *Migrator) failDeletePhase(namespace string, revisions []*release.Release, err error) {
+	m.failReleases(namespace, versionsByRelease(revisions), err)
+}
+
+// failReleases reports copied releases as failed with the given failed
+// versions each, see failDeletePhase.
+func (m *Migrator) failReleases(namespace string, failed map[string][]int, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, name := range slices.Sorted(maps.Keys(failed)) {
+		result := &ReleaseResult{Type: "release", Context: m.kubeContext, Release: name, Namespace: namespace, Status: "failed", Error: err.Error(), FailedVersions: failed[name]}
+		m.summary.readd(result, "copied")
+		m.writeResult(result)
+	}
+}
+
+// versionsByRelease returns the versions of the given revisions by release
+// name.
+func versionsByRelease(revisions []*release.Release) map[string][]int {
+	versions := make(map[string][]int)
+	for _, rls := range revisions {
+		versions[rls.Name] = append(versions[rls.Name], rls.Version)
+	}
+	return versions
+}
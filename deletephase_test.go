@@ -0,0 +1,119 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/release"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+func TestDeletePhaseFailureInAll(t *testing.T) {
+	tests := []struct {
+		name       string
+		failIn     string
+		wantErr    bool
+		wantFailed map[string]int
+		wantCopied map[string]int
+		wantHooks  string
+	}{
+		{
+			name:       "all deletes succeed",
+			wantCopied: map[string]int{"a": 1, "b": 1},
+			wantFailed: map[string]int{"a": 0, "b": 0},
+			wantHooks:  "a/app.v1\nb/app.v1\n",
+		},
+		{
+			name:       "deletes fail in one namespace",
+			failIn:     "a",
+			wantErr:    true,
+			wantCopied: map[string]int{"a": 0, "b": 1},
+			wantFailed: map[string]int{"a": 1, "b": 0},
+			wantHooks:  "b/app.v1\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFlags(t)
+			oldDeletePhase, oldCleanup := deletePhase, cleanupOrphans
+			defer func() { deletePhase, cleanupOrphans = oldDeletePhase, oldCleanup }()
+			deletePhase, cleanupOrphans = true, true
+			// the post-hook runs only once the source revisions of its release are deleted
+			oldPostHook := postHook
+			defer func() { postHook = oldPostHook }()
+			dir := t.TempDir()
+			hooked := filepath.Join(dir, "hooked")
+			postHook = filepath.Join(dir, "hook.sh")
+			err := os.WriteFile(postHook, []byte("#!/bin/sh\necho \"$2/$1.v$3\" >> "+hooked+"\n"), 0o755)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			deletes := make(map[string]int)
+			api := &fakeConfigMaps{configMaps: make(map[string]*corev1.ConfigMap)}
+			api.intercept = func(f *fakeConfigMaps, r *http.Request) *apierrors.StatusError {
+				if r.Method != http.MethodDelete {
+					return nil
+				}
+				namespace := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/namespaces/"), "/")[0]
+				deletes[namespace]++
+				if namespace == tt.failIn {
+					return apierrors.NewInternalError(errors.New("etcd is unavailable"))
+				}
+				return nil
+			}
+			for _, namespace := range []string{"a", "b"} {
+				api.put(releaseConfigMap(t, testRelease("app", namespace, 1, release.StatusDeployed)))
+			}
+			m := fakeMigrator(t, api)
+
+			err = m.migrateAll()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("migrateAll returned %v, want error: %t", err, tt.wantErr)
+			}
+			for _, ns := range m.summary.Namespaces {
+				if ns.Copied != tt.wantCopied[ns.Namespace] || ns.Failed != tt.wantFailed[ns.Namespace] {
+					t.Errorf("namespace %s has %d copied and %d failed releases, want %d and %d", ns.Namespace, ns.Copied, ns.Failed, tt.wantCopied[ns.Namespace], tt.wantFailed[ns.Namespace])
+				}
+			}
+			got, _ := os.ReadFile(hooked)
+			if lines := strings.SplitAfter(string(got), "\n"); strings.Join(slices.Sorted(slices.Values(lines)), "") != tt.wantHooks {
+				t.Errorf("post-hook ran for %q, want %q", got, tt.wantHooks)
+			}
+			if tt.failIn != "" {
+				if len(m.summary.FailedReleases) != 1 || m.summary.FailedReleases[0] != tt.failIn+"/app" {
+					t.Errorf("failed releases are %v, want %s/app", m.summary.FailedReleases, tt.failIn)
+				}
+				// the one failed delete of the delete phase, none by -cleanup-orphans
+				if deletes[tt.failIn] != 1 {
+					t.Errorf("%d deletes in namespace %s, want 1", deletes[tt.failIn], tt.failIn)
+				}
+			}
+		})
+	}
+}
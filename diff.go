@@ -0,0 +1,132 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"sigs.k8s.io/yaml"
+)
+
+// printReleaseDiff prints a unified diff of the values and the manifest of a
+// source revision and its existing copy in the target, for -dry-run-detail.
+func printReleaseDiff(source *release.Release, target *release.Release) error {
+	sourceValues, err := yaml.Marshal(source.Config)
+	if err != nil {
+		return err
+	}
+	targetValues, err := yaml.Marshal(target.Config)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s.v%d", source.Name, source.Version)
+	identical := true
+	for _, file := range []struct {
+		name           string
+		source, target string
+	}{
+		{"values.yaml", string(sourceValues), string(targetValues)},
+		{"manifest.yaml", source.Manifest, target.Manifest},
+	} {
+		diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(file.source),
+			B:        difflib.SplitLines(file.target),
+			FromFile: "source/" + name + "/" + file.name,
+			ToFile:   "target/" + name + "/" + file.name,
+			Context:  3,
+		})
+		if err != nil {
+			return err
+		}
+		if diff != "" {
+			identical = false
+			fmt.Fprint(logOutput(), diff)
+		}
+	}
+	if identical {
+		logf("release %s version %d: values and manifest are identical in target", source.Name, source.Version)
+	}
+	return nil
+}
+
+// dumpMismatch writes a source revision and its differing copy in the target
+// as indented JSON, along with a unified diff of the two, to a directory below
+// -diff-dir and returns that directory.
+func dumpMismatch(helmStorage *storage.Storage, source *release.Release) (string, error) {
+	target, err := helmStorage.Get(source.Name, source.Version)
+	if err != nil {
+		return "", err
+	}
+	sourceJSON, err := json.MarshalIndent(relocated(source, target.Namespace), "", "  ")
+	if err != nil {
+		return "", err
+	}
+	targetJSON, err := json.MarshalIndent(target, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(sourceJSON)),
+		B:        difflib.SplitLines(string(targetJSON)),
+		FromFile: "source.json",
+		ToFile:   "target.json",
+		Context:  3,
+	})
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(diffDir, source.Namespace, source.Name+".v"+strconv.Itoa(source.Version))
+	err = os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return "", err
+	}
+	for name, data := range map[string][]byte{
+		"source.json": sourceJSON,
+		"target.json": targetJSON,
+		"diff.txt":    []byte(diff),
+	} {
+		err = os.WriteFile(filepath.Join(dir, name), data, 0o600)
+		if err != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// mismatchDetail dumps a differing revision with -diff-dir and returns a
+// hint pointing to the dump for the error message, or an empty string.
+func mismatchDetail(helmStorage *storage.Storage, source *release.Release) string {
+	if diffDir == "" {
+		return ""
+	}
+	dir, err := dumpMismatch(helmStorage, source)
+	if err != nil {
+		errorf(ErrorRecord{Release: source.Name, Namespace: source.Namespace, Version: source.Version, Operation: "diff"}, "failed to write the differences of release %s version %d to -diff-dir: %s", source.Name, source.Version, err)
+		return ""
+	}
+	return ", see " + dir
+}
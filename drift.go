@@ -0,0 +1,159 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DriftResult describes a release whose revisions differ between the source
+// and the target driver.
+type DriftResult struct {
+	Type         string `json:"type"`
+	Release      string `json:"release"`
+	Namespace    string `json:"namespace"`
+	OnlyInSource []int  `json:"only_in_source,omitempty"`
+	OnlyInTarget []int  `json:"only_in_target,omitempty"`
+}
+
+// DriftSummary is written after the DriftResults in JSON output mode.
+type DriftSummary struct {
+	Type         string `json:"type"`
+	Releases     int    `json:"releases"`
+	Drifted      int    `json:"drifted"`
+	OnlyInSource int    `json:"only_in_source"`
+	OnlyInTarget int    `json:"only_in_target"`
+}
+
+// reportDrift lists the revisions of the releases in -namespace, or in all
+// namespaces, that exist in only one of the source and the target driver.
+// Nothing is changed. Unlike verify, which compares the contents of migrated
+// revisions, it is meant to be run repeatedly during a migration window to
+// notice e.g. new deployments that still went to the source.
+func (m *Migrator) reportDrift(allNamespaces bool) error {
+	scope := namespace
+	if allNamespaces {
+		scope = metav1.NamespaceAll
+	}
+	sourceReleases, err := m.listReleases(allNamespaces)
+	if err != nil {
+		return err
+	}
+	sourceReleases = slices.DeleteFunc(sourceReleases, func(rls *release.Release) bool {
+		return !allNamespaces && rls.Namespace != namespace
+	})
+	targetReleases, err := m.listTargetReleases(scope)
+	if err != nil {
+		return err
+	}
+	releases := make(map[string]*release.Release)
+	for _, rls := range append(sourceReleases, targetReleases...) {
+		releases[rls.Namespace+"/"+rls.Name] = rls
+	}
+	sorted := make([]*release.Release, 0, len(releases))
+	for _, rls := range releases {
+		sorted = append(sorted, rls)
+	}
+	releaseutil.SortByName(sorted)
+
+	summary := DriftSummary{Type: "drift-summary", Releases: len(sorted)}
+	for _, rls := range sorted {
+		sourceVersions, err := m.sourceVersions(rls.Namespace, rls.Name)
+		if err != nil {
+			return err
+		}
+		targetVersions, err := m.targetVersions(rls.Namespace, rls.Name)
+		if err != nil {
+			return err
+		}
+		result := DriftResult{Type: "drift", Release: rls.Name, Namespace: rls.Namespace}
+		for _, version := range sourceVersions {
+			if !slices.Contains(targetVersions, version) {
+				result.OnlyInSource = append(result.OnlyInSource, version)
+			}
+		}
+		for _, version := range targetVersions {
+			if !slices.Contains(sourceVersions, version) {
+				result.OnlyInTarget = append(result.OnlyInTarget, version)
+			}
+		}
+		if len(result.OnlyInSource)+len(result.OnlyInTarget) == 0 {
+			continue
+		}
+		summary.Drifted++
+		summary.OnlyInSource += len(result.OnlyInSource)
+		summary.OnlyInTarget += len(result.OnlyInTarget)
+		if output == "json" {
+			writeJSON(result)
+		} else {
+			logf("release %s/%s: versions %v only in source, %v only in target", rls.Namespace, rls.Name, result.OnlyInSource, result.OnlyInTarget)
+		}
+	}
+	if output == "json" {
+		writeJSON(summary)
+	} else {
+		logf("%d releases, %d drifted, %d revisions only in source, %d only in target", summary.Releases, summary.Drifted, summary.OnlyInSource, summary.OnlyInTarget)
+	}
+	if summary.Drifted > 0 {
+		return fmt.Errorf("%d releases drifted between source and target", summary.Drifted)
+	}
+	return nil
+}
+
+// sourceVersions returns the sorted versions of a release in the source
+// driver for a source namespace, which are empty if it only exists in the
+// target.
+func (m *Migrator) sourceVersions(sourceNS string, releaseName string) ([]int, error) {
+	hist, err := m.storedHistory(releaseName, sourceNS)
+	if err != nil && !errors.Is(err, driver.ErrReleaseNotFound) {
+		return nil, err
+	}
+	return revisionVersions(hist), nil
+}
+
+// targetVersions returns the sorted versions of a release in the target
+// driver for a source namespace.
+func (m *Migrator) targetVersions(sourceNS string, releaseName string) ([]int, error) {
+	helmStorage, err := m.targetStorage(targetNamespaceFor(sourceNS))
+	if err != nil {
+		return nil, err
+	}
+	hist, err := helmStorage.History(releaseName)
+	if err != nil && !errors.Is(err, driver.ErrReleaseNotFound) {
+		return nil, err
+	}
+	releaseutil.SortByRevision(hist)
+	return revisionVersions(hist), nil
+}
+
+func revisionVersions(hist []*release.Release) []int {
+	versions := make([]int, len(hist))
+	for i, rls := range hist {
+		versions[i] = rls.Version
+	}
+	return versions
+}
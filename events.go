@@ -0,0 +1,101 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"helm.sh/helm/v3/pkg/storage/driver"
+	corev1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// emitEvent records a Kubernetes Event for a migrated or failed release with
+// -emit-events. Migrated releases refer to the storage object of their latest
+// revision in the target, failed releases to the one in the source. Failing
+// to emit an event is logged, but does not fail the migration.
+func (m *Migrator) emitEvent(result *ReleaseResult) {
+	if !emitEvents || m.clientset == nil {
+		return
+	}
+	var (
+		reason, eventType, note string
+		driverName, namespace   string
+		versions                []int
+	)
+	clientset := m.clientset
+	switch result.Status {
+	case "migrated", "copied":
+		helmStorage, err := m.targetStorage(targetNamespaceFor(result.Namespace))
+		if err != nil {
+			return
+		}
+		reason, eventType = "Migrated", corev1.EventTypeNormal
+		note = fmt.Sprintf("Migrated release %s from %s to %s", result.Release, m.actionCfg.Releases.Name(), helmStorage.Name())
+		driverName, namespace, versions = helmStorage.Name(), targetNamespaceFor(result.Namespace), result.Versions
+		clientset = m.targetClientset
+	case "failed":
+		reason, eventType = "MigrationFailed", corev1.EventTypeWarning
+		note = fmt.Sprintf("Failed to migrate release %s: %s", result.Release, result.Error)
+		driverName, namespace, versions = m.actionCfg.Releases.Name(), result.Namespace, result.FailedVersions
+	default:
+		return
+	}
+	var kind string
+	switch driverName {
+	case driver.ConfigMapsDriverName:
+		kind = "ConfigMap"
+	case driver.SecretsDriverName:
+		kind = "Secret"
+	default:
+		return
+	}
+	objectName := result.Release
+	if len(versions) > 0 {
+		objectName = releaseKey(result.Release, versions[len(versions)-1])
+	}
+	if len(note) > 1024 {
+		note = note[:1024]
+	}
+	hostname, _ := os.Hostname()
+	event := &eventsv1.Event{
+		ObjectMeta:          metav1.ObjectMeta{GenerateName: objectName + ".", Namespace: namespace},
+		EventTime:           metav1.NowMicro(),
+		ReportingController: fieldManager,
+		ReportingInstance:   fieldManager + "-" + hostname,
+		Action:              "Migrate",
+		Reason:              reason,
+		Type:                eventType,
+		Note:                note,
+		Regarding: corev1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       kind,
+			Namespace:  namespace,
+			Name:       objectName,
+		},
+	}
+	_, err := clientset.EventsV1().Events(namespace).Create(context.Background(), event, metav1.CreateOptions{})
+	if err != nil {
+		errorf(ErrorRecord{Release: result.Release, Namespace: namespace, Operation: "emit-event"}, "failed to emit event for release %s/%s: %s", result.Namespace, result.Release, err)
+	}
+}
@@ -0,0 +1,111 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"sigs.k8s.io/yaml"
+)
+
+// failedManifestDetail writes the manifest of a revision that failed to
+// migrate to -fail-dir and returns a hint pointing to it for the error
+// message, or an empty string.
+func failedManifestDetail(rls *release.Release) string {
+	if failDir == "" {
+		return ""
+	}
+	manifest := rls.Manifest
+	if redact {
+		var omitted int
+		manifest, omitted = redactSecrets(manifest)
+		if omitted > 0 {
+			warnf("omitted %d documents that could not be parsed from the manifest of release %s version %d, -redact cannot tell whether they are Secrets", omitted, rls.Name, rls.Version)
+		}
+	}
+	dir := filepath.Join(failDir, rls.Namespace)
+	path := filepath.Join(dir, rls.Name+".v"+strconv.Itoa(rls.Version)+".yaml")
+	err := os.MkdirAll(dir, 0o755)
+	if err == nil {
+		err = os.WriteFile(path, []byte(manifest), 0o600)
+	}
+	if err != nil {
+		warnf("failed to write the manifest of release %s version %d to -fail-dir: %s", rls.Name, rls.Version, err)
+		return ""
+	}
+	return ", manifest in " + path
+}
+
+// redactSecrets replaces the values in the data and stringData of each Secret
+// in a manifest. Documents that are not Secrets are kept as they are.
+// Documents that cannot be parsed might be Secrets, so they are replaced by a
+// placeholder, and their number is returned.
+func redactSecrets(manifest string) (string, int) {
+	docs := releaseutil.SplitManifests(manifest)
+	keys := make([]string, 0, len(docs))
+	for key := range docs {
+		keys = append(keys, key)
+	}
+	sort.Sort(releaseutil.BySplitManifestsOrder(keys))
+
+	out := make([]string, 0, len(keys))
+	omitted := 0
+	for _, key := range keys {
+		doc := docs[key]
+		// keep the "# Source:" comment naming the template
+		var comments []string
+		for _, line := range strings.Split(doc, "\n") {
+			if !strings.HasPrefix(line, "#") {
+				break
+			}
+			comments = append(comments, line+"\n")
+		}
+		var object map[string]any
+		err := yaml.Unmarshal([]byte(doc), &object)
+		if err == nil && object["kind"] == "Secret" {
+			for _, field := range []string{"data", "stringData"} {
+				values, ok := object[field].(map[string]any)
+				if !ok {
+					continue
+				}
+				for name := range values {
+					values[name] = "REDACTED"
+				}
+			}
+			var redacted []byte
+			redacted, err = yaml.Marshal(object)
+			if err == nil {
+				doc = strings.Join(comments, "") + strings.TrimSuffix(string(redacted), "\n")
+			}
+		}
+		if err != nil {
+			omitted++
+			doc = strings.Join(comments, "") + "# REDACTED: this document could not be parsed"
+		}
+		out = append(out, doc)
+	}
+	return "---\n" + strings.Join(out, "\n---\n") + "\n", omitted
+}
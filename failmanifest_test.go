@@ -0,0 +1,72 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedactSecrets(t *testing.T) {
+	tests := []struct {
+		name        string
+		manifest    string
+		want        []string
+		notWant     []string
+		wantOmitted int
+	}{
+		{
+			name:     "Secret",
+			manifest: "---\n# Source: app/templates/secret.yaml\napiVersion: v1\nkind: Secret\nmetadata:\n  name: app\ndata:\n  password: c2VjcmV0\nstringData:\n  token: secret\n",
+			want:     []string{"# Source: app/templates/secret.yaml", "password: REDACTED", "token: REDACTED"},
+			notWant:  []string{"c2VjcmV0", "token: secret"},
+		},
+		{
+			name:     "ConfigMap",
+			manifest: "---\n# Source: app/templates/configmap.yaml\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: app\ndata:\n  port: \"8080\"\n",
+			want:     []string{"port: \"8080\""},
+		},
+		{
+			name:        "unparsable Secret",
+			manifest:    "---\n# Source: app/templates/secret.yaml\napiVersion: v1\nkind: Secret\nmetadata:\n  name: app\ndata:\n  password: c2VjcmV0\n  token: {{ .Values.token }\n",
+			want:        []string{"# Source: app/templates/secret.yaml", "could not be parsed"},
+			notWant:     []string{"c2VjcmV0", "kind: Secret"},
+			wantOmitted: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, omitted := redactSecrets(tt.manifest)
+			if omitted != tt.wantOmitted {
+				t.Errorf("omitted %d documents, want %d", omitted, tt.wantOmitted)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("redacted manifest does not contain %q:\n%s", want, got)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(got, notWant) {
+					t.Errorf("redacted manifest contains %q:\n%s", notWant, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// stringList is a flag value that can be given multiple times, each time with
+// one or more comma-separated values.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// nameMatcher matches a name either exactly or, if the pattern is prefixed
+// with "re:", against a regular expression.
+type nameMatcher struct {
+	name  string
+	regex *regexp.Regexp
+}
+
+func parseMatchers(patterns []string) ([]nameMatcher, error) {
+	matchers := make([]nameMatcher, 0, len(patterns))
+	for _, pattern := range patterns {
+		expr, isRegex := strings.CutPrefix(pattern, "re:")
+		if !isRegex {
+			matchers = append(matchers, nameMatcher{name: pattern})
+			continue
+		}
+		regex, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		matchers = append(matchers, nameMatcher{regex: regex})
+	}
+	return matchers, nil
+}
+
+func (n nameMatcher) matches(name string) bool {
+	if n.regex != nil {
+		return n.regex.MatchString(name)
+	}
+	return n.name == name
+}
+
+func matchesAny(matchers []nameMatcher, name string) bool {
+	for _, matcher := range matchers {
+		if matcher.matches(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// namespaceSelected reports whether a namespace matches -include-namespaces,
+// if given, and does not match -exclude-namespaces, if given.
+func namespaceSelected(namespace string) bool {
+	if includeNamespaceRegex != nil && !includeNamespaceRegex.MatchString(namespace) {
+		return false
+	}
+	if excludeNamespaceRegex != nil && excludeNamespaceRegex.MatchString(namespace) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,52 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// clusterFingerprint identifies the target cluster by the UID of its
+// kube-system namespace. It is assigned when the cluster is created, so unlike
+// context names or API server addresses, it is never shared between clusters.
+func (m *Migrator) clusterFingerprint() (string, error) {
+	ns, err := m.targetClientset.CoreV1().Namespaces().Get(context.Background(), metav1.NamespaceSystem, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	return string(ns.UID), nil
+}
+
+// checkClusterFingerprint aborts unless the target cluster has the
+// fingerprint given with -expect-cluster.
+func (m *Migrator) checkClusterFingerprint() error {
+	fingerprint, err := m.clusterFingerprint()
+	if err != nil {
+		return fmt.Errorf("failed to determine the cluster fingerprint for -expect-cluster: %w", err)
+	}
+	if fingerprint != expectCluster {
+		return fmt.Errorf("the target cluster has the fingerprint %s, but -expect-cluster is %s", fingerprint, expectCluster)
+	}
+	infof("the target cluster has the fingerprint %s from -expect-cluster", fingerprint)
+	return nil
+}
@@ -0,0 +1,83 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// getRelease writes a revision of a release in the source driver to stdout,
+// either as its stored payload with -encoding raw, or as the decoded release
+// with json or yaml. A revision of 0 selects the latest one.
+func (m *Migrator) getRelease(releaseName string, revision int) error {
+	rls, err := m.storedRevision(releaseName, revision)
+	if err != nil {
+		return err
+	}
+	var buf []byte
+	switch encoding {
+	case "raw":
+		payload, err := m.storedPayload(rls)
+		if err != nil {
+			return err
+		}
+		buf = []byte(payload)
+	case "json":
+		writeJSON(rls)
+		return nil
+	case "yaml":
+		buf, err = yaml.Marshal(rls)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = os.Stdout.Write(buf)
+	return err
+}
+
+// storedPayload returns the payload of the storage object of a revision as
+// found in the source. For drivers other than ConfigMaps and Secrets, the
+// revision is encoded the way these drivers store it.
+func (m *Migrator) storedPayload(rls *release.Release) (string, error) {
+	key := releaseKey(rls.Name, rls.Version)
+	switch m.actionCfg.Releases.Name() {
+	case driver.ConfigMapsDriverName:
+		cm, err := m.clientset.CoreV1().ConfigMaps(rls.Namespace).Get(context.Background(), key, metav1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("failed to get ConfigMap %s: %w", key, err)
+		}
+		return cm.Data["release"], nil
+	case driver.SecretsDriverName:
+		secret, err := m.clientset.CoreV1().Secrets(rls.Namespace).Get(context.Background(), key, metav1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("failed to get Secret %s: %w", key, err)
+		}
+		return string(secret.Data["release"]), nil
+	default:
+		return encodeRelease(rls)
+	}
+}
@@ -0,0 +1,597 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"slices"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	helmtime "helm.sh/helm/v3/pkg/time"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kblabels "k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+// Helm 2 support, enabled with -from helm2.
+//
+// Tiller stored each revision as a ConfigMap "<release>.v<version>" in its own
+// namespace, labeled with OWNER=TILLER, NAME and VERSION. The payload is a
+// base64 encoded, gzipped protobuf message of type hapi.release.Release.
+// Helm 3 has no decoder for it, so the fields are read with protowire
+// according to the hapi protobuf definitions and converted to Helm 3's
+// release schema, like the helm-2to3 plugin does. Limitations:
+//
+//   - only Tiller's default ConfigMaps storage is supported, not Secrets
+//   - crd-install hooks and the last test suite run are dropped, because
+//     Helm 3 has no equivalent for them
+//   - the chart's engine and tillerVersion metadata are dropped
+//   - Tiller itself and its RBAC objects are left in place
+//   - the checks and hooks around the migration of a Helm 3 release are not
+//     implemented, the flags in helm2UnsupportedFlags are rejected instead
+
+// helm2UnsupportedFlags are the flags that only apply to the migration of
+// Helm 3 releases.
+var helm2UnsupportedFlags = []string{
+	"decode-check", "delete-grace", "force-delete", "helm-check", "min-age",
+	"only-latest-if-deployed", "pending-only", "post-hook", "prune-older-than",
+	"prune-source-only", "reconcile", "strict-verify", "validate-chart",
+}
+
+// setHelm2UnsupportedFlags returns the flags given on the command line that
+// -from helm2 does not support.
+func setHelm2UnsupportedFlags() []string {
+	var set []string
+	flag.Visit(func(f *flag.Flag) {
+		if slices.Contains(helm2UnsupportedFlags, f.Name) {
+			set = append(set, "-"+f.Name)
+		}
+	})
+	return set
+}
+
+// migrateHelm2 migrates the Helm 2 releases stored by Tiller in
+// -tiller-namespace. An empty releaseName migrates all releases; an empty
+// namespace does not filter by the namespace the releases are deployed to.
+func (m *Migrator) migrateHelm2(releaseName string, namespace string) error {
+	if m.clientset == nil {
+		return errors.New("-from helm2 requires a cluster")
+	}
+	histories, err := m.tillerHistories(releaseName)
+	if err != nil {
+		return err
+	}
+	if releaseName != "" && len(histories) == 0 {
+		return fmt.Errorf("no Helm 2 release %s found in namespace %s", releaseName, tillerNamespace)
+	}
+	names := make([]string, 0, len(histories))
+	for name := range histories {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var selected []string
+	deletes := 0
+	for _, name := range names {
+		hist := histories[name]
+		if namespace != "" && hist[len(hist)-1].Namespace != namespace {
+			continue
+		}
+		if matchesAny(excludeMatchers, name) {
+			logf("excluding release %s/%s", hist[len(hist)-1].Namespace, name)
+			continue
+		}
+		selected = append(selected, name)
+		deletes += len(limitHistory(hist))
+	}
+	if deleteLimited() {
+		err := checkDeleteCount(deletes)
+		if err != nil {
+			return err
+		}
+	}
+	for _, name := range selected {
+		hist := histories[name]
+		err := m.migrateHelm2Release(name, hist)
+		if err != nil {
+			errorf(ErrorRecord{Release: name, Namespace: hist[len(hist)-1].Namespace, Operation: "migrate"}, "%s", err)
+		}
+		err = m.checkMaxFailures()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tillerHistories reads and converts the Helm 2 revisions in Tiller's
+// storage, grouped by release name and sorted by version.
+func (m *Migrator) tillerHistories(releaseName string) (map[string][]*release.Release, error) {
+	selector := kblabels.Set{"OWNER": "TILLER"}
+	if releaseName != "" {
+		selector["NAME"] = releaseName
+	}
+	list, err := m.clientset.CoreV1().ConfigMaps(tillerNamespace).List(context.Background(), metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	histories := make(map[string][]*release.Release)
+	for _, cm := range list.Items {
+		rls, err := decodeHelm2Release(cm.Data["release"])
+		if err != nil {
+			errorf(ErrorRecord{Release: cm.Labels["NAME"], Namespace: tillerNamespace, Operation: "decode"}, "skipping Helm 2 release ConfigMap %s/%s: %s", tillerNamespace, cm.Name, err)
+			continue
+		}
+		histories[rls.Name] = append(histories[rls.Name], rls)
+	}
+	for _, hist := range histories {
+		releaseutil.SortByRevision(hist)
+	}
+	return histories, nil
+}
+
+// migrateHelm2Release writes the converted revisions of a Helm 2 release to
+// the target driver in the namespace the release is deployed to, and then
+// deletes the Tiller ConfigMaps unless -keep-source is set.
+func (m *Migrator) migrateHelm2Release(releaseName string, records []*release.Release) (err error) {
+	sourceNS := records[len(records)-1].Namespace
+	targetNS := targetNamespaceFor(sourceNS)
+	result := &ReleaseResult{Type: "release", Context: m.kubeContext, Release: releaseName, Namespace: sourceNS, Status: "migrated"}
+	if targetNS != sourceNS {
+		result.TargetNamespace = targetNS
+	}
+	defer func() {
+		m.report(result, err)
+		m.emitEvent(result)
+	}()
+	progress.start(sourceNS, releaseName)
+	helmStorage, err := m.targetStorage(targetNS)
+	if err != nil {
+		return err
+	}
+	hist := limitHistory(records)
+	if len(hist) == 0 {
+		result.Status = "skipped"
+		return nil
+	}
+	if metadata := hist[len(hist)-1].Chart.Metadata; metadata != nil {
+		result.Chart = metadata.Name + "-" + metadata.Version
+	}
+	if dryRun {
+		result.Status = "dry-run"
+		return diffRelease(result, hist, helmStorage)
+	}
+	existing, err := helmStorage.History(releaseName)
+	if err != nil && !errors.Is(err, driver.ErrReleaseNotFound) {
+		return err
+	}
+	if targetLatest, sourceLatest := latestVersion(existing), latestVersion(hist); targetLatest > sourceLatest {
+		warnf("skipping Helm 2 release %s: target already has version %d, Tiller only has up to version %d", releaseName, targetLatest, sourceLatest)
+		result.Status = "skipped"
+		return nil
+	}
+	if keepSource {
+		result.Status = "copied"
+	}
+	failed := false
+	for _, rls := range orderedHistory(hist) {
+		rls.Namespace = targetNS
+		err = m.createRelease(helmStorage, targetNS, tillerNamespace, rls)
+		if resumeOnConflict && errors.Is(err, driver.ErrReleaseExists) {
+			err = resumeConflict(helmStorage, rls)
+		}
+		if err != nil {
+			failed = true
+			errorf(ErrorRecord{Release: releaseName, Namespace: targetNS, Version: rls.Version, Operation: createOperation(err)}, "failed to migrate Helm 2 release %s version %d: %s", releaseName, rls.Version, err)
+			result.FailedVersions = append(result.FailedVersions, rls.Version)
+			continue
+		}
+		if !keepSource {
+			key := fmt.Sprintf("%s.v%d", releaseName, rls.Version)
+			err = m.clientset.CoreV1().ConfigMaps(tillerNamespace).Delete(context.Background(), key, metav1.DeleteOptions{})
+			if err != nil {
+				failed = true
+				errorf(ErrorRecord{Release: releaseName, Namespace: tillerNamespace, Version: rls.Version, Operation: "delete"}, "failed to delete Helm 2 release ConfigMap %s/%s: %s", tillerNamespace, key, err)
+				result.FailedVersions = append(result.FailedVersions, rls.Version)
+				continue
+			}
+		}
+		infof("migrated Helm 2 release %s version %d", releaseName, rls.Version)
+		result.Versions = append(result.Versions, rls.Version)
+	}
+	if failed {
+		return fmt.Errorf("failed to migrate Helm 2 release %s", releaseName)
+	}
+	return nil
+}
+
+// decodeHelm2Release parses the payload of a Tiller storage object and
+// converts it to a Helm 3 release.
+func decodeHelm2Release(data string) (*release.Release, error) {
+	b, err := decodePayload(data)
+	if err != nil {
+		return nil, err
+	}
+	rls := &release.Release{Info: &release.Info{}, Chart: &chart.Chart{}}
+	var configRaw string
+	err = eachField(b, func(num protowire.Number, v fieldValue) error {
+		switch num {
+		case 1:
+			rls.Name = string(v.bytes)
+		case 2:
+			return decodeHelm2Info(v.bytes, rls.Info)
+		case 3:
+			return decodeHelm2Chart(v.bytes, rls.Chart)
+		case 4:
+			configRaw = decodeHelm2Config(v.bytes)
+		case 5:
+			rls.Manifest = string(v.bytes)
+		case 6:
+			hook, err := decodeHelm2Hook(v.bytes)
+			if err != nil || hook == nil {
+				return err
+			}
+			rls.Hooks = append(rls.Hooks, hook)
+		case 7:
+			rls.Version = int(int32(v.varint))
+		case 8:
+			rls.Namespace = string(v.bytes)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid Helm 2 release: %w", err)
+	}
+	rls.Config, err = parseValues(configRaw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid values of Helm 2 release %s: %w", rls.Name, err)
+	}
+	if rls.Name == "" || rls.Version == 0 {
+		return nil, errors.New("invalid Helm 2 release: name or version missing")
+	}
+	return rls, nil
+}
+
+var helm2StatusCodes = map[uint64]release.Status{
+	0: release.StatusUnknown,
+	1: release.StatusDeployed,
+	2: release.StatusUninstalled,
+	3: release.StatusSuperseded,
+	4: release.StatusFailed,
+	5: release.StatusUninstalling,
+	6: release.StatusPendingInstall,
+	7: release.StatusPendingUpgrade,
+	8: release.StatusPendingRollback,
+}
+
+func decodeHelm2Info(b []byte, info *release.Info) error {
+	return eachField(b, func(num protowire.Number, v fieldValue) error {
+		var err error
+		switch num {
+		case 1: // hapi.release.Status
+			err = eachField(v.bytes, func(num protowire.Number, v fieldValue) error {
+				switch num {
+				case 1:
+					info.Status = helm2StatusCodes[v.varint]
+				case 4:
+					info.Notes = string(v.bytes)
+				}
+				return nil
+			})
+		case 2:
+			info.FirstDeployed, err = decodeTimestamp(v.bytes)
+		case 3:
+			info.LastDeployed, err = decodeTimestamp(v.bytes)
+		case 4:
+			info.Deleted, err = decodeTimestamp(v.bytes)
+		case 5:
+			info.Description = string(v.bytes)
+		}
+		return err
+	})
+}
+
+func decodeHelm2Chart(b []byte, c *chart.Chart) error {
+	metadata := &chart.Metadata{}
+	var valuesRaw string
+	err := eachField(b, func(num protowire.Number, v fieldValue) error {
+		switch num {
+		case 1:
+			return decodeHelm2Metadata(v.bytes, metadata)
+		case 2: // hapi.chart.Template
+			var template chart.File
+			err := eachField(v.bytes, func(num protowire.Number, v fieldValue) error {
+				switch num {
+				case 1:
+					template.Name = string(v.bytes)
+				case 2:
+					template.Data = slices.Clone(v.bytes)
+				}
+				return nil
+			})
+			c.Templates = append(c.Templates, &template)
+			return err
+		case 3:
+			dependency := &chart.Chart{}
+			err := decodeHelm2Chart(v.bytes, dependency)
+			c.AddDependency(dependency)
+			return err
+		case 4:
+			valuesRaw = decodeHelm2Config(v.bytes)
+		case 5: // google.protobuf.Any
+			var file chart.File
+			err := eachField(v.bytes, func(num protowire.Number, v fieldValue) error {
+				switch num {
+				case 1:
+					file.Name = string(v.bytes)
+				case 2:
+					file.Data = slices.Clone(v.bytes)
+				}
+				return nil
+			})
+			c.Files = append(c.Files, &file)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if metadata.APIVersion == "" {
+		metadata.APIVersion = chart.APIVersionV1
+	}
+	c.Metadata = metadata
+	c.Values, err = parseValues(valuesRaw)
+	return err
+}
+
+func decodeHelm2Metadata(b []byte, metadata *chart.Metadata) error {
+	return eachField(b, func(num protowire.Number, v fieldValue) error {
+		switch num {
+		case 1:
+			metadata.Name = string(v.bytes)
+		case 2:
+			metadata.Home = string(v.bytes)
+		case 3:
+			metadata.Sources = append(metadata.Sources, string(v.bytes))
+		case 4:
+			metadata.Version = string(v.bytes)
+		case 5:
+			metadata.Description = string(v.bytes)
+		case 6:
+			metadata.Keywords = append(metadata.Keywords, string(v.bytes))
+		case 7:
+			maintainer := &chart.Maintainer{}
+			metadata.Maintainers = append(metadata.Maintainers, maintainer)
+			return eachField(v.bytes, func(num protowire.Number, v fieldValue) error {
+				switch num {
+				case 1:
+					maintainer.Name = string(v.bytes)
+				case 2:
+					maintainer.Email = string(v.bytes)
+				case 3:
+					maintainer.URL = string(v.bytes)
+				}
+				return nil
+			})
+		case 9:
+			metadata.Icon = string(v.bytes)
+		case 10:
+			metadata.APIVersion = string(v.bytes)
+		case 11:
+			metadata.Condition = string(v.bytes)
+		case 12:
+			metadata.Tags = string(v.bytes)
+		case 13:
+			metadata.AppVersion = string(v.bytes)
+		case 14:
+			metadata.Deprecated = v.varint != 0
+		case 16:
+			var key, value string
+			err := eachField(v.bytes, func(num protowire.Number, v fieldValue) error {
+				switch num {
+				case 1:
+					key = string(v.bytes)
+				case 2:
+					value = string(v.bytes)
+				}
+				return nil
+			})
+			if metadata.Annotations == nil {
+				metadata.Annotations = make(map[string]string)
+			}
+			metadata.Annotations[key] = value
+			return err
+		case 17:
+			metadata.KubeVersion = string(v.bytes)
+		}
+		return nil
+	})
+}
+
+// decodeHelm2Config returns the raw YAML of a hapi.chart.Config.
+func decodeHelm2Config(b []byte) string {
+	var raw string
+	_ = eachField(b, func(num protowire.Number, v fieldValue) error {
+		if num == 1 {
+			raw = string(v.bytes)
+		}
+		return nil
+	})
+	return raw
+}
+
+var (
+	helm2HookEvents = map[uint64]release.HookEvent{
+		1:  release.HookPreInstall,
+		2:  release.HookPostInstall,
+		3:  release.HookPreDelete,
+		4:  release.HookPostDelete,
+		5:  release.HookPreUpgrade,
+		6:  release.HookPostUpgrade,
+		7:  release.HookPreRollback,
+		8:  release.HookPostRollback,
+		9:  release.HookTest,
+		10: release.HookTest,
+	}
+	helm2HookDeletePolicies = map[uint64]release.HookDeletePolicy{
+		0: release.HookSucceeded,
+		1: release.HookFailed,
+		2: release.HookBeforeHookCreation,
+	}
+)
+
+// decodeHelm2Hook converts a hapi.release.Hook. It returns nil for hooks that
+// only have events without a Helm 3 equivalent, i.e. crd-install hooks.
+func decodeHelm2Hook(b []byte) (*release.Hook, error) {
+	hook := &release.Hook{}
+	var events []uint64
+	err := eachField(b, func(num protowire.Number, v fieldValue) error {
+		var err error
+		switch num {
+		case 1:
+			hook.Name = string(v.bytes)
+		case 2:
+			hook.Kind = string(v.bytes)
+		case 3:
+			hook.Path = string(v.bytes)
+		case 4:
+			hook.Manifest = string(v.bytes)
+		case 5:
+			events, err = v.appendVarints(events)
+		case 6:
+			hook.LastRun.StartedAt, err = decodeTimestamp(v.bytes)
+			hook.LastRun.CompletedAt = hook.LastRun.StartedAt
+			hook.LastRun.Phase = release.HookPhaseSucceeded
+		case 7:
+			hook.Weight = int(int32(v.varint))
+		case 8:
+			var policies []uint64
+			policies, err = v.appendVarints(policies)
+			for _, policy := range policies {
+				if p, ok := helm2HookDeletePolicies[policy]; ok {
+					hook.DeletePolicies = append(hook.DeletePolicies, p)
+				}
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, event := range events {
+		if e, ok := helm2HookEvents[event]; ok && !slices.Contains(hook.Events, e) {
+			hook.Events = append(hook.Events, e)
+		}
+	}
+	if len(hook.Events) == 0 {
+		return nil, nil
+	}
+	return hook, nil
+}
+
+func decodeTimestamp(b []byte) (helmtime.Time, error) {
+	var seconds, nanos uint64
+	err := eachField(b, func(num protowire.Number, v fieldValue) error {
+		switch num {
+		case 1:
+			seconds = v.varint
+		case 2:
+			nanos = v.varint
+		}
+		return nil
+	})
+	return helmtime.Time{Time: time.Unix(int64(seconds), int64(int32(nanos))).UTC()}, err
+}
+
+// parseValues parses the raw YAML values of a Helm 2 chart or release.
+func parseValues(raw string) (map[string]any, error) {
+	values := make(map[string]any)
+	if raw == "" {
+		return values, nil
+	}
+	err := yaml.Unmarshal([]byte(raw), &values)
+	return values, err
+}
+
+// fieldValue is the value of a single protobuf field, as read by eachField.
+type fieldValue struct {
+	typ    protowire.Type
+	varint uint64
+	bytes  []byte
+}
+
+// appendVarints appends the values of a repeated varint field, which proto3
+// encodes packed into a single length-delimited value.
+func (v fieldValue) appendVarints(values []uint64) ([]uint64, error) {
+	if v.typ == protowire.VarintType {
+		return append(values, v.varint), nil
+	}
+	b := v.bytes
+	for len(b) > 0 {
+		value, n := protowire.ConsumeVarint(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		values = append(values, value)
+		b = b[n:]
+	}
+	return values, nil
+}
+
+// eachField calls fn for every varint and length-delimited field of a
+// protobuf message. Fields of other wire types are skipped.
+func eachField(b []byte, fn func(protowire.Number, fieldValue) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		v := fieldValue{typ: typ}
+		switch typ {
+		case protowire.VarintType:
+			v.varint, n = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			v.bytes, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.VarintType && typ != protowire.BytesType {
+			continue
+		}
+		err := fn(num, v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"helm.sh/helm/v3/pkg/release"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// tillerPayload is the Tiller storage payload of version 3 of a Helm 2
+// release "web" in namespace web, with a test hook and a crd-install hook.
+const tillerPayload = "H4sIAAAAAAAC/2SQT0rzUBRHCfnSL15FaqygGYUMHASSkCeFkKF2AUWw8zTvWp++f+TdUjtzKd1PN+DIgSuRiCjo8HfgcOAH/gaXUQ2T0EvHC+EEJQ9EtinLgY/Cl7fdPohH4ev7bh9k4zu76luOSWeUlUgYL2ACgV4J/ZwGVcGKq8d/VcGmUQEXhMrKltCVHK00W4Waim2rZHTyJDRvktk3hvQYjnq0UnSta5Lq12aQneV5Dn80NoVwg8uc0FHkzw2PT3+qA/zspV/i3HDIvIM6YJfwf9C6nkfnN2tHRt2iM+u+wxneCy1IGJ15h7V/PdzzMQBewVvuJAEAAA=="
+
+func TestDecodeHelm2Release(t *testing.T) {
+	rls, err := decodeHelm2Release(tillerPayload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		field string
+		got   any
+		want  any
+	}{
+		{"name", rls.Name, "web"},
+		{"namespace", rls.Namespace, "web"},
+		{"version", rls.Version, 3},
+		{"status", rls.Info.Status, release.StatusDeployed},
+		{"notes", rls.Info.Notes, "Visit http://web"},
+		{"description", rls.Info.Description, "Upgrade complete"},
+		{"first deployed", rls.Info.FirstDeployed.Time, time.Unix(1500000000, 0).UTC()},
+		{"last deployed", rls.Info.LastDeployed.Time, time.Unix(1500000600, 0).UTC()},
+		{"chart name", rls.Chart.Metadata.Name, "nginx"},
+		{"chart version", rls.Chart.Metadata.Version, "1.2.3"},
+		{"app version", rls.Chart.Metadata.AppVersion, "1.25"},
+		{"chart API version", rls.Chart.Metadata.APIVersion, "v1"},
+		{"templates", len(rls.Chart.Templates), 1},
+		{"template name", rls.Chart.Templates[0].Name, "templates/deployment.yaml"},
+		{"chart values", rls.Chart.Values["replicas"], float64(1)},
+		{"release values", rls.Config["replicas"], float64(2)},
+		{"manifest", rls.Manifest, "---\nkind: Deployment\n"},
+		// the crd-install hook has no Helm 3 equivalent
+		{"hooks", len(rls.Hooks), 1},
+		{"hook name", rls.Hooks[0].Name, "web-test"},
+		{"hook events", slices.Equal(rls.Hooks[0].Events, []release.HookEvent{release.HookTest}), true},
+		{"hook weight", rls.Hooks[0].Weight, 5},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s is %v, want %v", tt.field, tt.got, tt.want)
+		}
+	}
+}
+
+func TestDecodeHelm2ReleaseInvalid(t *testing.T) {
+	for name, payload := range map[string]string{
+		"not base64":       "%%%",
+		"truncated":        tillerPayload[:len(tillerPayload)/2],
+		"no name, version": "H4sIAAAAAAAC/wMAAAAAAAAAAAA=",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := decodeHelm2Release(payload)
+			if err == nil {
+				t.Error("decoding succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestMigrateHelm2Release(t *testing.T) {
+	tests := []struct {
+		name         string
+		inTarget     int
+		wantStatus   string
+		wantInTiller bool
+	}{
+		{name: "absent in target", wantStatus: "migrated"},
+		{name: "newer version in target", inTarget: 4, wantStatus: "skipped", wantInTiller: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFlags(t)
+			oldTiller := tillerNamespace
+			defer func() { tillerNamespace = oldTiller }()
+			tillerNamespace = "kube-system"
+
+			api := &fakeConfigMaps{configMaps: make(map[string]*corev1.ConfigMap)}
+			api.put(&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "web.v3", Namespace: "kube-system", Labels: map[string]string{"OWNER": "TILLER", "NAME": "web", "VERSION": "3"}},
+				Data:       map[string]string{"release": tillerPayload},
+			})
+			m := fakeMigrator(t, api)
+			target, err := m.targetStorage("web")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.inTarget > 0 {
+				err = target.Create(testRelease("web", "web", tt.inTarget, release.StatusDeployed))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err = m.migrateHelm2("web", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			counts := m.summary.ReleaseCounts
+			if got := map[string]int{"migrated": counts.Migrated, "skipped": counts.Skipped}[tt.wantStatus]; got != 1 {
+				t.Errorf("summary is %s, want 1 release %s", counts, tt.wantStatus)
+			}
+			_, inTiller := api.configMaps[configMapKey("kube-system", "web.v3")]
+			if inTiller != tt.wantInTiller {
+				t.Errorf("Tiller ConfigMap exists: %t, want %t", inTiller, tt.wantInTiller)
+			}
+		})
+	}
+}
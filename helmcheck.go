@@ -0,0 +1,57 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+)
+
+// checkHelmReadable reads a migrated release back from the target the way
+// `helm get` and `helm history` do, and fails unless Helm finds all of the
+// migrated versions. Unlike the byte comparison of verify, this catches
+// target objects that exist, but lack the labels Helm queries by.
+func (m *Migrator) checkHelmReadable(helmStorage *storage.Storage, releaseName string, versions []int) error {
+	cfg := *m.actionCfg
+	cfg.Releases = helmStorage
+
+	latest, err := action.NewGet(&cfg).Run(releaseName)
+	if err != nil {
+		return fmt.Errorf("helm get failed: %w", err)
+	}
+	if latest.Version < slices.Max(versions) {
+		return fmt.Errorf("helm get returned version %d instead of %d", latest.Version, slices.Max(versions))
+	}
+
+	hist, err := action.NewHistory(&cfg).Run(releaseName)
+	if err != nil {
+		return fmt.Errorf("helm history failed: %w", err)
+	}
+	for _, version := range versions {
+		if !slices.ContainsFunc(hist, func(rls *release.Release) bool { return rls.Version == version }) {
+			return fmt.Errorf("helm history does not list version %d", version)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// runPostHook invokes the -post-hook executable for a migrated release. The
+// release name, namespace and version are passed both as arguments and as
+// environment variables.
+func runPostHook(releaseName string, namespace string, version int) error {
+	cmd := exec.Command(postHook, releaseName, namespace, strconv.Itoa(version))
+	cmd.Env = append(os.Environ(),
+		"RELEASE_NAME="+releaseName,
+		"RELEASE_NAMESPACE="+namespace,
+		"RELEASE_VERSION="+strconv.Itoa(version),
+	)
+	cmd.Stdout = logOutput()
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// runReleaseHook runs the -post-hook for a migrated release. A failure is only
+// logged, unless -hook-fatal makes it fail the release.
+func runReleaseHook(releaseName string, namespace string, version int) error {
+	err := runPostHook(releaseName, namespace, version)
+	if err == nil {
+		return nil
+	}
+	if hookFatal {
+		return fmt.Errorf("post-hook failed for release %s: %w", releaseName, err)
+	}
+	errorf(ErrorRecord{Release: releaseName, Namespace: namespace, Version: version, Operation: "post-hook"}, "post-hook failed for release %s: %s", releaseName, err)
+	return nil
+}
@@ -0,0 +1,69 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"fmt"
+
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+)
+
+// releaseStatus returns the status of a revision. Hand-written or corrupted
+// records may lack the info that holds it, in which case it is unknown.
+func releaseStatus(rls *release.Release) release.Status {
+	if rls.Info == nil {
+		return release.StatusUnknown
+	}
+	return rls.Info.Status
+}
+
+// checkInfo fails the migration of a release if one of its revisions lacks
+// the info with its status and deployment timestamps, which the Helm drivers
+// need to store it.
+func checkInfo(hist []*release.Release) error {
+	for _, rls := range hist {
+		if rls.Info == nil {
+			return fmt.Errorf("version %d has no release info, its status is unknown", rls.Version)
+		}
+	}
+	return nil
+}
+
+// infoDefaultingDriver lists revisions without info with an unknown status,
+// since Helm's list action reads the status of every revision it finds.
+// Helm does not list releases with an unknown status by default, while the
+// revisions read for a migration keep their missing info so that checkInfo
+// can report them.
+type infoDefaultingDriver struct {
+	driver.Driver
+}
+
+func (d *infoDefaultingDriver) List(filter func(*release.Release) bool) ([]*release.Release, error) {
+	releases, err := d.Driver.List(filter)
+	for i, rls := range releases {
+		if rls.Info == nil {
+			copied := *rls
+			copied.Info = &release.Info{Status: release.StatusUnknown}
+			releases[i] = &copied
+		}
+	}
+	return releases, err
+}
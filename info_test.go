@@ -0,0 +1,93 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+)
+
+func TestReleaseWithoutInfo(t *testing.T) {
+	useFlags(t)
+	pruneOlderThan = 1
+	defer func() { pruneOlderThan = 0 }()
+
+	rls := &release.Release{Name: "app", Namespace: "default", Version: 1}
+	if status := releaseStatus(rls); status != release.StatusUnknown {
+		t.Errorf("releaseStatus returned %q, want %q", status, release.StatusUnknown)
+	}
+	if isExpired(rls) {
+		t.Error("isExpired considers a revision without info expired")
+	}
+	if status := storageLabels(rls)["status"]; status != release.StatusUnknown.String() {
+		t.Errorf("storageLabels set status %q, want %q", status, release.StatusUnknown)
+	}
+}
+
+func TestMigrateReleaseWithoutInfo(t *testing.T) {
+	tests := []struct {
+		name       string
+		withInfo   []bool
+		wantErr    bool
+		wantSource int
+	}{
+		{name: "all revisions have info", withInfo: []bool{true, true}, wantSource: 0},
+		{name: "latest revision lacks info", withInfo: []bool{true, false}, wantErr: true, wantSource: 2},
+		{name: "older revision lacks info", withInfo: []bool{false, true}, wantErr: true, wantSource: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFlags(t)
+			var seeded []*release.Release
+			for i := range tt.withInfo {
+				seeded = append(seeded, testRelease("app", "default", i+1, release.StatusSuperseded))
+			}
+			m, err := newMemoryMigrator("default", seeded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the memory driver keeps the seeded revisions, which simulates
+			// records that were stored without info
+			for i, withInfo := range tt.withInfo {
+				if !withInfo {
+					seeded[i].Info = nil
+				}
+			}
+
+			err = m.migrateRelease("app", "default")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("migrateRelease returned %v, want error: %t", err, tt.wantErr)
+			}
+			if tt.wantErr && m.summary.Failed != 1 {
+				t.Errorf("summary counts %d failed releases, want 1", m.summary.Failed)
+			}
+			remaining, err := m.sourceStorage("default").History("app")
+			if err != nil && !errors.Is(err, driver.ErrReleaseNotFound) {
+				t.Fatal(err)
+			}
+			if len(remaining) != tt.wantSource {
+				t.Errorf("%d revisions left in source, want %d", len(remaining), tt.wantSource)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/release"
+	"sigs.k8s.io/yaml"
+)
+
+// InspectedRelease is the decoded content of a stored revision, as printed by
+// the inspect subprogram.
+type InspectedRelease struct {
+	Name      string          `json:"name"`
+	Namespace string          `json:"namespace"`
+	Version   int             `json:"version"`
+	Status    string          `json:"status"`
+	Chart     *chart.Metadata `json:"chart"`
+	Values    map[string]any  `json:"values"`
+	Manifest  string          `json:"manifest"`
+}
+
+// inspectRelease prints the chart metadata, values and manifest of a
+// revision in the source driver as YAML, or as a JSON object with -output
+// json. A revision of 0 selects the latest one.
+func (m *Migrator) inspectRelease(releaseName string, revision int) error {
+	rls, err := m.storedRevision(releaseName, revision)
+	if err != nil {
+		return err
+	}
+	inspected := InspectedRelease{
+		Name:      rls.Name,
+		Namespace: rls.Namespace,
+		Version:   rls.Version,
+		Values:    rls.Config,
+		Manifest:  rls.Manifest,
+		Status:    releaseStatus(rls).String(),
+	}
+	if metadata, err := chartMetadata(rls); err == nil {
+		inspected.Chart = metadata
+	}
+	if output == "json" {
+		writeJSON(inspected)
+		return nil
+	}
+	buf, err := yaml.Marshal(inspected)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(buf)
+	return err
+}
+
+// storedRevision returns a revision of a release in the source driver, or its
+// latest revision if revision is 0.
+func (m *Migrator) storedRevision(releaseName string, revision int) (*release.Release, error) {
+	hist, err := m.storedHistory(releaseName, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if len(hist) == 0 {
+		return nil, fmt.Errorf("release %s not found in namespace %s", releaseName, namespace)
+	}
+	if revision == 0 {
+		return hist[len(hist)-1], nil
+	}
+	for _, rls := range hist {
+		if rls.Version == revision {
+			return rls, nil
+		}
+	}
+	return nil, fmt.Errorf("release %s has no version %d in namespace %s", releaseName, revision, namespace)
+}
@@ -0,0 +1,71 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+)
+
+// RevisionHash records the SHA-256 of a revision before it was created in the
+// target and of the copy read back from the target with -strict-verify.
+type RevisionHash struct {
+	Version int    `json:"version"`
+	Source  string `json:"source"`
+	Target  string `json:"target,omitempty"`
+}
+
+// releaseHash returns the hex-encoded SHA-256 of the canonical encoding of a
+// decoded release. encoding/json sorts map keys, so equal releases always
+// hash the same regardless of the driver they were read from.
+func releaseHash(rls *release.Release) (string, error) {
+	data, err := json.Marshal(rls)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// checkIntegrity reads a created revision back from the target, adds the
+// hashes of both copies to the result and fails if they differ.
+func checkIntegrity(result *ReleaseResult, helmStorage *storage.Storage, sourceHash string, version int) error {
+	hash := RevisionHash{Version: version, Source: sourceHash}
+	defer func() { result.Hashes = append(result.Hashes, hash) }()
+
+	rls, err := helmStorage.Get(result.Release, version)
+	if err != nil {
+		return fmt.Errorf("cannot read back version %d: %w", version, err)
+	}
+	hash.Target, err = releaseHash(rls)
+	if err != nil {
+		return err
+	}
+	if hash.Target != sourceHash {
+		return fmt.Errorf("SHA-256 of version %d is %s in target, but was %s in source", version, hash.Target, sourceHash)
+	}
+	debugf("release %s version %d has SHA-256 %s in source and target", result.Release, version, sourceHash)
+	return nil
+}
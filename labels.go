@@ -0,0 +1,68 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import "helm.sh/helm/v3/pkg/release"
+
+// labelKeys are the label keys identifying the release storage objects of a
+// driver. Helm always writes standardLabelKeys, but legacy or customized
+// setups may have stored releases under other keys, which can be given for
+// the source with -owner-label, -name-label, -status-label and
+// -version-label.
+type labelKeys struct {
+	Owner   string
+	Name    string
+	Status  string
+	Version string
+}
+
+var standardLabelKeys = labelKeys{Owner: "owner", Name: "name", Status: "status", Version: "version"}
+
+// sourceLabelKeys is set from the -*-label flags.
+var sourceLabelKeys = standardLabelKeys
+
+// isStandard reports whether the label keys are the ones Helm uses, so that
+// Helm's own listing, which hardcodes them, can be used.
+func (k labelKeys) isStandard() bool {
+	return k == standardLabelKeys
+}
+
+// normalizeLabels renames the source label keys of a revision read from the
+// source driver to Helm's standard keys, so that the target driver does not
+// carry the legacy keys along.
+func normalizeLabels(rls *release.Release) {
+	if sourceLabelKeys.isStandard() || rls.Labels == nil {
+		return
+	}
+	renames := map[string]string{
+		sourceLabelKeys.Owner:   standardLabelKeys.Owner,
+		sourceLabelKeys.Name:    standardLabelKeys.Name,
+		sourceLabelKeys.Status:  standardLabelKeys.Status,
+		sourceLabelKeys.Version: standardLabelKeys.Version,
+	}
+	labels := make(map[string]string, len(rls.Labels))
+	for key, value := range rls.Labels {
+		if standard, ok := renames[key]; ok {
+			key = standard
+		}
+		labels[key] = value
+	}
+	rls.Labels = labels
+}
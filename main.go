@@ -20,31 +20,249 @@
 package main
 
 import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"maps"
 	"os"
-	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
 
 	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/kube"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
 	"helm.sh/helm/v3/pkg/storage"
 	"helm.sh/helm/v3/pkg/storage/driver"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kblabels "k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 )
 
+// deleteBatchPause is the pause between two batches of source deletes with
+// -delete-batch-size.
+const deleteBatchPause = 200 * time.Millisecond
+
+// maxStorageObjectSize is the maximum size of the data in a ConfigMap or
+// Secret.
+const maxStorageObjectSize = 1024 * 1024
+
+// readOnlySubcommands are the subprograms that only print information about
+// releases. They do not produce a migration summary.
+var readOnlySubcommands = map[string]bool{
+	"report":    true,
+	"preflight": true,
+	"verify":    true,
+	"inspect":   true,
+	"get":       true,
+	"drift":     true,
+}
+
 var (
-	kubeconfig string
-	to         string
-	namespace  string
-	maxHist    int
+	kubeconfig          string
+	to                  string
+	namespace           string
+	maxHist             int
+	dryRun              bool
+	postHook            string
+	hookFatal           bool
+	owner               string
+	maxRetries          int
+	keepSource          bool
+	pruneOnly           bool
+	output              string
+	serverSide          bool
+	contexts            string
+	cleanupOrphans      bool
+	watch               bool
+	sourceSelector      string
+	deleteBatchSize     int
+	sinceVersion        int
+	quiet               bool
+	excludes            stringList
+	pruneOlderThan      time.Duration
+	forceDelete         bool
+	outputTemplate      string
+	namespaceList       stringList
+	retryFromReport     string
+	secretType          string
+	parallelism         int
+	maxNamespaces       int
+	onlyLatestDeployed  bool
+	serveAddr           string
+	decodeCheck         bool
+	jsonErrors          bool
+	reconcile           bool
+	maxFailures         int
+	from                string
+	chunkHistory        int
+	targetStoragePrefix string
+	planFile            string
+	minAge              time.Duration
+	deleteGrace         time.Duration
+	includeNamespaces   string
+	excludeNamespaces   string
+	revision            int
+	encoding            string
+	webhookURL          string
+	webhookHeaders      headerList
+	namespaceSelector   string
+	pendingOnly         bool
+	logLevel            string
+	dryRunDetail        bool
+	targetContext       string
+	preserveTimestamps  bool
+	retryBudget         int
+	latestOnly          bool
+	requireContext      bool
+	reportInterval      time.Duration
+	validateChart       bool
+	diffDir             string
+	helmCheck           bool
+	strictVerify        bool
+	expectCluster       string
+	traceAPI            bool
+	summaryOnly         bool
+	readOnly            bool
+	deletePhase         bool
+	failDir             string
+	onlyOrphaned        bool
+	maxDelete           int
+	orderedOutput       bool
+	redact              bool
+	historyOrder        string
+	countOnly           bool
+	annotationSelector  string
+	resumeOnConflict    bool
+	targetLabels        metadataList
+	targetAnnotations   metadataList
+
+	targetLabelMap      map[string]string
+	targetAnnotationMap map[string]string
+	annotationMatcher   kblabels.Selector
+	force               bool
+	tillerNamespace     string
+	sourceNamespace     string
+	targetNamespace     string
+	emitEvents          bool
+	yes                 bool
+
+	// parsed from sourceSelector, excludes, outputTemplate, retryFromReport
+	// and planFile
+	sourceLabels    kblabels.Set
+	excludeMatchers []nameMatcher
+	// includeNamespaceRegex and excludeNamespaceRegex are parsed from
+	// -include-namespaces and -exclude-namespaces, nil if not given.
+	includeNamespaceRegex *regexp.Regexp
+	excludeNamespaceRegex *regexp.Regexp
+	resultTemplate        *template.Template
+	failedReleases        []ReleaseResult
+	plan                  map[string]ReleaseResult
+	planOrder             []string
 )
 
 func main() {
-	flag.StringVar(&kubeconfig, "kubeconfig", filepath.Join(os.Getenv("HOME"), ".kube", "config"), "path to your kubeconfig file")
+	flag.StringVar(&kubeconfig, "kubeconfig", "", "path to your kubeconfig file, defaults to the files in $KUBECONFIG or ~/.kube/config")
 	flag.StringVar(&to, "to", "", "kind of resource to migrate to (configmap or secret)")
-	flag.StringVar(&namespace, "namespace", "default", "namespace containing releases to migrate")
-	flag.IntVar(&maxHist, "max", 1, "history length to migrate")
+	flag.StringVar(&namespace, "namespace", "default", "namespace containing releases to migrate, \"all\" for all namespaces")
+	flag.IntVar(&maxHist, "max", 1, "number of most recent revisions to migrate per release, 1 migrates only the latest, 0 migrates the whole history")
+	flag.BoolVar(&dryRun, "dry-run", false, "only report revision counts and sizes in source and target, without migrating anything, with -output json as a plan of the versions to create and delete")
+	flag.StringVar(&postHook, "post-hook", "", "executable to run after each migrated release, with -delete-phase once its source revisions are deleted, called with release name, namespace and version")
+	flag.BoolVar(&hookFatal, "hook-fatal", false, "treat a failing post-hook as a migration failure")
+	flag.StringVar(&owner, "owner", "helm", "expected value of the owner label on release storage objects, others are skipped")
+	flag.IntVar(&maxRetries, "max-retries", 3, "how often to retry deleting a source revision that was modified concurrently")
+	flag.BoolVar(&keepSource, "keep-source", false, "copy releases to the target without deleting them from the source")
+	flag.BoolVar(&pruneOnly, "prune-source-only", false, "only delete source revisions that are already present and identical in the target")
+	flag.StringVar(&output, "output", "text", "output format (text or json)")
+	flag.BoolVar(&serverSide, "server-side", false, "create target storage objects with server-side apply")
+	flag.StringVar(&contexts, "contexts", "", "comma-separated list of kube contexts to run against, or \"all\" for every context in the kubeconfig")
+	flag.BoolVar(&cleanupOrphans, "cleanup-orphans", false, "after migrating a namespace away from ConfigMaps, delete release ConfigMaps left behind for releases now in the target")
+	flag.BoolVar(&watch, "watch", false, "keep running and migrate releases as they are created or updated in the source (namespace and all only)")
+	flag.StringVar(&sourceSelector, "source-selector", "", "equality-based label selector applied by the API server when reading release storage objects (e.g. status=deployed)")
+	flag.IntVar(&deleteBatchSize, "delete-batch-size", 0, "number of migrated revisions whose source records are deleted concurrently, with a short pause between batches, 0 deletes each revision right after creating it without pausing")
+	flag.IntVar(&sinceVersion, "since-version", 0, "only migrate revisions with a version greater than this")
+	flag.BoolVar(&quiet, "quiet", false, "only print warnings, errors and results, but no progress messages")
+	flag.Var(&excludes, "exclude", "release names to skip in namespace and all, prefix with \"re:\" for a regular expression (can be repeated or comma-separated)")
+	flag.DurationVar(&pruneOlderThan, "prune-older-than", 0, "delete source revisions last deployed longer ago than this instead of migrating them, except for the deployed revision")
+	flag.BoolVar(&forceDelete, "force-delete", false, "DANGEROUS: delete source revisions that already exist in the target instead of failing, requires -yes")
+	flag.BoolVar(&yes, "yes", false, "confirm dangerous operations")
+	flag.StringVar(&outputTemplate, "output-template", "", "Go template rendered for the result of each release, e.g. '{{.Release}} {{.Status}}'")
+	flag.Var(&namespaceList, "namespaces", "restrict the all subprogram to these namespaces, which are listed one by one if listing all namespaces is forbidden (can be repeated or comma-separated)")
+	flag.StringVar(&retryFromReport, "retry-from-report", "", "JSON report of a previous run with -output json, only its failed releases are migrated again by namespace and all")
+	flag.StringVar(&secretType, "secret-type", defaultSecretType, "type of the Secrets created when migrating to secret")
+	flag.IntVar(&parallelism, "parallelism", 1, "number of releases migrated concurrently within a namespace")
+	flag.IntVar(&maxNamespaces, "max-concurrent-namespaces", 1, "number of namespaces migrated concurrently by the all subprogram")
+	flag.BoolVar(&onlyLatestDeployed, "only-latest-if-deployed", false, "only migrate the latest revision of each release, and skip releases whose latest revision is not deployed")
+	flag.StringVar(&serveAddr, "serve", "", "address (e.g. :8080) of an HTTP server exposing /progress and /results as JSON while the run lasts")
+	flag.BoolVar(&decodeCheck, "decode-check", false, "skip releases with a source storage object whose payload does not decode, instead of migrating the remaining revisions")
+	flag.StringVar(&sourceNamespace, "source-namespace", "", "namespace to read releases from, overrides -namespace")
+	flag.StringVar(&targetNamespace, "target-namespace", "", "namespace to write releases to in the target driver, defaults to the source namespace")
+	flag.BoolVar(&emitEvents, "emit-events", false, "record a Kubernetes Event for each migrated or failed release")
+	flag.StringVar(&sourceLabelKeys.Owner, "owner-label", standardLabelKeys.Owner, "key of the owner label on source release storage objects")
+	flag.StringVar(&sourceLabelKeys.Name, "name-label", standardLabelKeys.Name, "key of the release name label on source release storage objects")
+	flag.StringVar(&sourceLabelKeys.Status, "status-label", standardLabelKeys.Status, "key of the release status label on source release storage objects")
+	flag.StringVar(&sourceLabelKeys.Version, "version-label", standardLabelKeys.Version, "key of the release version label on source release storage objects")
+	flag.BoolVar(&jsonErrors, "json-errors", false, "write errors to stderr as JSON objects with release, namespace, version, operation and message")
+	flag.BoolVar(&reconcile, "reconcile", false, "create the revisions missing in the target, verify all of them, then delete them from the source unless -keep-source is set; safe to run repeatedly")
+	flag.IntVar(&maxFailures, "max-failures", 0, "abort namespace and all after this many releases failed, 0 never aborts")
+	flag.StringVar(&from, "from", "", "read releases from $HELM_DRIVER if empty, from configmap or secret regardless of $HELM_DRIVER, from whichever of ConfigMaps and Secrets is not -to with \"auto\", or from the ConfigMaps of a Helm 2 Tiller with \"helm2\" (release, namespace and all only)")
+	flag.StringVar(&tillerNamespace, "tiller-namespace", "kube-system", "namespace of the Helm 2 Tiller storage for -from helm2")
+	flag.IntVar(&chunkHistory, "chunk-history", 0, "fetch and migrate the history of each release in chunks of this many revisions to bound memory usage, 0 fetches it at once")
+	flag.StringVar(&targetStoragePrefix, "target-storage-prefix", "", "prefix for the storage keys written to the target driver; Helm cannot get prefixed revisions by key, e.g. for rollback")
+	flag.StringVar(&planFile, "plan-file", "", "output of a previous run with -dry-run -output json, only the releases and versions it lists are migrated by namespace and all")
+	flag.BoolVar(&force, "force", false, "execute -plan-file even if the cluster drifted from it")
+	flag.DurationVar(&minAge, "min-age", 0, "skip releases whose latest revision was deployed less than this long ago, e.g. 5m")
+	flag.DurationVar(&deleteGrace, "delete-grace", 0, "wait this long after creating revisions in the target and only delete them from the source if they can be read back")
+	flag.StringVar(&includeNamespaces, "include-namespaces", "", "regular expression restricting the all subprogram to the namespaces it matches, e.g. '^team-'")
+	flag.StringVar(&excludeNamespaces, "exclude-namespaces", "", "regular expression of namespaces skipped by the all subprogram")
+	flag.IntVar(&revision, "revision", 0, "version of the release printed by inspect and get, 0 for the latest")
+	flag.StringVar(&encoding, "encoding", "json", "encoding of the release written by get (raw for the stored payload, json or yaml)")
+	flag.StringVar(&webhookURL, "webhook-url", "", "URL to POST the JSON summary of the run to when it completes, failures to do so are only logged")
+	flag.Var(&webhookHeaders, "webhook-header", "header sent with the -webhook-url request, e.g. 'Authorization: Bearer <token>' (can be repeated)")
+	flag.StringVar(&namespaceSelector, "namespace-selector", "", "label selector restricting the all subprogram to the namespaces it matches (e.g. migrate=true)")
+	flag.BoolVar(&pendingOnly, "pending-only", false, "skip releases in namespace and all whose revisions are all identical in the target already")
+	flag.StringVar(&logLevel, "log-level", "info", "most verbose messages to print (error, warning, info, or debug for the messages of the Helm SDK), -quiet caps it at warning")
+	flag.BoolVar(&dryRunDetail, "dry-run-detail", false, "with -dry-run, print a diff of the values and manifest of each revision that already exists in the target")
+	flag.StringVar(&targetContext, "target-context", "", "kube context of the cluster to migrate to, from the same kubeconfig, defaults to the source context")
+	flag.BoolVar(&preserveTimestamps, "preserve-timestamps", false, "copy the createdAt and modifiedAt labels of source storage objects to the created target objects")
+	flag.IntVar(&retryBudget, "retry-budget", 0, "total number of retries allowed across the whole run, in addition to -max-retries per revision, 0 for no limit")
+	flag.BoolVar(&latestOnly, "latest-only", false, "DANGEROUS: only migrate the latest revision of each release and delete all older revisions from the source, requires -yes")
+	flag.BoolVar(&requireContext, "require-context", os.Getenv("HELM_MIGRATE_RELEASE_REQUIRE_CONTEXT") == "true", "refuse to run against the current context of the kubeconfig unless -contexts is given, enabled by default if $HELM_MIGRATE_RELEASE_REQUIRE_CONTEXT is true")
+	flag.DurationVar(&reportInterval, "report-interval", 0, "print a summary of the progress to stderr at this interval, even with -quiet, 0 disables it")
+	flag.BoolVar(&validateChart, "validate-chart", false, "skip releases with a revision whose chart fails Helm's chart validation")
+	flag.StringVar(&diffDir, "diff-dir", "", "directory to write the source and target copies of revisions that verify or -reconcile find to differ to, along with a diff")
+	flag.BoolVar(&helmCheck, "helm-check", false, "after migrating a release, read it back from the target with Helm's get and history actions and fail the release unless Helm finds every migrated version")
+	flag.BoolVar(&strictVerify, "strict-verify", false, "hash each revision before creating it and its copy read back from the target with SHA-256, keep the source revision if they differ, and report the hashes")
+	flag.StringVar(&expectCluster, "expect-cluster", "", "fingerprint of the target cluster (the UID of its kube-system namespace, as printed by preflight), abort before doing anything if it differs")
+	flag.BoolVar(&traceAPI, "trace-api", false, "log the method, resource, namespace, status and latency of every Kubernetes API call, requires -log-level debug")
+	flag.BoolVar(&summaryOnly, "summary-only", false, "only print the summary of the run to stdout, which implies -quiet and writes all other messages to stderr, and exit non-zero if any release failed")
+	flag.BoolVar(&readOnly, "read-only", false, "refuse to create, update or delete anything, which only allows report, preflight, verify, inspect, get, drift and -dry-run")
+	flag.BoolVar(&deletePhase, "delete-phase", false, "copy all releases of a namespace first, then verify them and delete them from the source, keeping all of them if any release in the namespace failed (namespace and all only)")
+	flag.StringVar(&failDir, "fail-dir", "", "directory to write the manifest of each revision that fails to migrate to")
+	flag.BoolVar(&redact, "redact", true, "replace the values of Secrets in the manifests written to -fail-dir")
+	flag.BoolVar(&onlyOrphaned, "only-orphaned", false, "only migrate releases with storage objects that helm list does not show, e.g. because of their status (namespace and all only)")
+	flag.IntVar(&maxDelete, "max-delete", 0, "abort before migrating anything if the run would delete more than this many source revisions, unless -yes is set, 0 for no limit")
+	flag.BoolVar(&orderedOutput, "ordered-output", false, "with the all subprogram, print the results of each namespace together with its summary in alphabetical order of the namespaces, even if they are migrated concurrently; implies -quiet")
+	flag.Var(&targetLabels, "target-label", "label in the form key=value added to every storage object created in the target, e.g. to satisfy admission webhooks (can be repeated)")
+	flag.Var(&targetAnnotations, "target-annotation", "annotation in the form key=value added to every storage object created in the target, e.g. to satisfy admission webhooks (can be repeated)")
+	flag.StringVar(&historyOrder, "history-order", "ascending", "order in which the revisions of a release are created in the target and deleted from the source (ascending or descending by version)")
+	flag.BoolVar(&countOnly, "count-only", false, "with preflight, only print the number of revisions a migration would create and their total size instead of checking permissions, and warn about revisions close to the size limit of a storage object")
+	flag.StringVar(&annotationSelector, "annotation-selector", "", "selector on the annotations of the storage object of the latest revision of each release, releases that do not match are not listed (e.g. environment=prod)")
+	flag.BoolVar(&resumeOnConflict, "resume-on-conflict", false, "treat revisions that already exist in the target with the same content as migrated and delete them from the source, e.g. to finish an interrupted run")
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Migrate Helm releases from $HELM_DRIVER to other drivers.\n\n")
 		fmt.Fprintf(os.Stderr, "Usage:\n")
@@ -52,136 +270,1975 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Subcommands:\n")
 		fmt.Fprintf(os.Stderr, "  release <release name>\n")
 		fmt.Fprintf(os.Stderr, "  namespace\n")
-		fmt.Fprintf(os.Stderr, "  all\n\n")
+		fmt.Fprintf(os.Stderr, "  all\n")
+		fmt.Fprintf(os.Stderr, "  report [all]\n")
+		fmt.Fprintf(os.Stderr, "  preflight [all]\n")
+		fmt.Fprintf(os.Stderr, "  verify [all]\n")
+		fmt.Fprintf(os.Stderr, "  repair-duplicates [all]\n")
+		fmt.Fprintf(os.Stderr, "  inspect <release name>\n")
+		fmt.Fprintf(os.Stderr, "  get <release name>\n")
+		fmt.Fprintf(os.Stderr, "  drift [all]\n")
+		fmt.Fprintf(os.Stderr, "  relocate <release name>\n\n")
 		flag.PrintDefaults()
 	}
 	flag.Parse()
+	startedAt := time.Now()
 	subcommands := flag.Arg(0)
 	if subcommands == "" {
-		fmt.Println("subprogram is required")
+		flagErrorf("subprogram is required")
 		os.Exit(1)
 	}
-	migrator, err := NewMigrator(kubeconfig, namespace)
-	if err != nil {
-		fmt.Println(err)
+	level, ok := logLevels[logLevel]
+	if !ok {
+		flagErrorf("unknown log level %s", logLevel)
+		os.Exit(1)
+	}
+	verbosity = level
+	if quiet || summaryOnly || orderedOutput {
+		verbosity = min(verbosity, levelWarning)
+	}
+	if subcommands == "selftest" {
+		err := runSelftest()
+		if err != nil {
+			errorf(ErrorRecord{Operation: "selftest"}, "selftest failed: %s", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if output != "text" && output != "json" {
+		flagErrorf("unknown output format %s", output)
 		os.Exit(1)
 	}
 	switch subcommands {
+	case "relocate":
+		if flag.Arg(1) == "" {
+			flagErrorf("release name is required")
+			os.Exit(1)
+		}
+		if sourceNamespace == "" || targetNamespace == "" || sourceNamespace == targetNamespace {
+			flagErrorf("the relocate subprogram requires different -source-namespace and -target-namespace")
+			os.Exit(1)
+		}
+		if to != "" || from != "" {
+			flagErrorf("the relocate subprogram keeps the $HELM_DRIVER driver and does not accept -to or -from")
+			os.Exit(1)
+		}
+		var err error
+		to, err = relocateDriver(os.Getenv("HELM_DRIVER"))
+		if err != nil {
+			flagErrorf("%s", err)
+			os.Exit(1)
+		}
+	case "inspect", "get":
+		if flag.Arg(1) == "" {
+			flagErrorf("release name is required")
+			os.Exit(1)
+		}
+		if encoding != "raw" && encoding != "json" && encoding != "yaml" {
+			flagErrorf("unknown encoding %s", encoding)
+			os.Exit(1)
+		}
+		if revision < 0 {
+			flagErrorf("-revision must not be negative")
+			os.Exit(1)
+		}
 	case "release":
-		releaseName := flag.Arg(1)
-		if releaseName == "" {
-			fmt.Println("release name is required")
+		if flag.Arg(1) == "" {
+			flagErrorf("release name is required")
 			os.Exit(1)
 		}
-		err = migrator.migrateRelease(releaseName, namespace)
-	case "namespace":
-		err = migrator.migrateNamespace(namespace)
-	case "all":
-		err = migrator.migrateAll()
+		if watch {
+			flagErrorf("-watch is not supported for the release subprogram")
+			os.Exit(1)
+		}
+	case "namespace", "all", "report", "preflight", "verify", "repair-duplicates", "drift":
 	default:
-		err = fmt.Errorf("unknown subprogram %s", subcommands)
+		flagErrorf("unknown subprogram %s", subcommands)
+		os.Exit(1)
 	}
-	if err != nil {
-		fmt.Println(err)
+	switch from {
+	case "":
+	case "auto":
+		if _, err := autoSourceDriver(); err != nil {
+			flagErrorf("%s", err)
+			os.Exit(1)
+		}
+		if os.Getenv("HELM_DRIVER") == "memory" || reconcile || pruneOnly {
+			flagErrorf("-from auto cannot be combined with HELM_DRIVER=memory, -reconcile or -prune-source-only")
+			os.Exit(1)
+		}
+	case "configmap", "configmaps", "secret", "secrets":
+		if os.Getenv("HELM_DRIVER") == "memory" {
+			flagErrorf("-from %s cannot be combined with HELM_DRIVER=memory", from)
+			os.Exit(1)
+		}
+		if kubeDriverName(from) == kubeDriverName(to) && subcommands != "relocate" {
+			flagErrorf("-from and -to must be different drivers")
+			os.Exit(1)
+		}
+	case "helm2":
+		if watch || (subcommands != "release" && subcommands != "namespace" && subcommands != "all") {
+			flagErrorf("-from helm2 only supports the release, namespace and all subprograms without -watch")
+			os.Exit(1)
+		}
+		if unsupported := setHelm2UnsupportedFlags(); len(unsupported) > 0 {
+			flagErrorf("-from helm2 does not support %s", strings.Join(unsupported, ", "))
+			os.Exit(1)
+		}
+	default:
+		flagErrorf("unknown source %s for -from", from)
 		os.Exit(1)
 	}
-}
-
-type Migrator struct {
-	clientset *kubernetes.Clientset
-	actionCfg *action.Configuration
-}
-
-func NewMigrator(kubeconfig string, namespace string) (*Migrator, error) {
-	kubecfg, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if sourceNamespace != "" {
+		namespace = sourceNamespace
+	}
+	if targetNamespace != "" && (subcommands == "all" || namespace == "all" || flag.Arg(1) == "all") {
+		flagErrorf("-target-namespace only applies to a single source namespace")
+		os.Exit(1)
+	}
+	if planFile != "" && (watch || dryRun || retryFromReport != "" || chunkHistory > 0 || from == "helm2") {
+		flagErrorf("-plan-file cannot be combined with -watch, -dry-run, -retry-from-report, -chunk-history or -from helm2")
+		os.Exit(1)
+	}
+	if preserveTimestamps && (os.Getenv("HELM_DRIVER") == "memory" || to == "memory" || from == "helm2") {
+		flagErrorf("-preserve-timestamps requires ConfigMaps or Secrets as source and target")
+		os.Exit(1)
+	}
+	if expectCluster != "" && os.Getenv("HELM_DRIVER") == "memory" {
+		flagErrorf("-expect-cluster cannot be used with the memory driver")
+		os.Exit(1)
+	}
+	if orderedOutput && ((subcommands != "all" && (subcommands != "namespace" || namespace != "all")) || watch || retryFromReport != "" || planFile != "" || from == "helm2" || summaryOnly) {
+		flagErrorf("-ordered-output only applies to the all subprogram without -watch, -retry-from-report, -plan-file, -from helm2 or -summary-only")
+		os.Exit(1)
+	}
+	if maxDelete < 0 {
+		flagErrorf("-max-delete must not be negative")
+		os.Exit(1)
+	}
+	if maxDelete > 0 && (watch || subcommands == "repair-duplicates") {
+		flagErrorf("-max-delete cannot be combined with -watch or the repair-duplicates subprogram, which cannot count their deletes up front")
+		os.Exit(1)
+	}
+	if onlyOrphaned && ((subcommands != "namespace" && subcommands != "all") || watch || retryFromReport != "" || planFile != "" || from == "helm2") {
+		flagErrorf("-only-orphaned only applies to the namespace and all subprograms without -watch, -retry-from-report, -plan-file or -from helm2")
+		os.Exit(1)
+	}
+	if deletePhase && ((subcommands != "namespace" && subcommands != "all") || watch || retryFromReport != "" || planFile != "") {
+		flagErrorf("-delete-phase only applies to the namespace and all subprograms without -watch, -retry-from-report or -plan-file")
+		os.Exit(1)
+	}
+	if deletePhase && (keepSource || reconcile || pruneOnly || latestOnly || from == "helm2") {
+		flagErrorf("-delete-phase cannot be combined with -keep-source, -reconcile, -prune-source-only, -latest-only or -from helm2")
+		os.Exit(1)
+	}
+	if readOnly && !readOnlySubcommands[subcommands] && !dryRun {
+		flagErrorf("the %s subprogram changes releases, which -read-only forbids unless -dry-run is set", subcommands)
+		os.Exit(1)
+	}
+	if summaryOnly && (readOnlySubcommands[subcommands] || dryRun || outputTemplate != "") {
+		flagErrorf("-summary-only only applies to migrations and cannot be combined with -dry-run or -output-template")
+		os.Exit(1)
+	}
+	if traceAPI && verbosity < levelDebug {
+		flagErrorf("-trace-api requires -log-level debug")
+		os.Exit(1)
+	}
+	// the memory driver lives only as long as this process, so a memory
+	// target is always empty when a run starts
+	if to == "memory" && (subcommands == "verify" || subcommands == "drift" || pruneOnly || pendingOnly) {
+		flagErrorf("-to memory cannot be used to verify, compare or prune against releases migrated by a previous run, the memory driver is not persisted across runs")
+		os.Exit(1)
+	}
+	if helmCheck && targetStoragePrefix != "" {
+		flagErrorf("-helm-check cannot be combined with -target-storage-prefix, Helm cannot read prefixed revisions")
+		os.Exit(1)
+	}
+	if dryRunDetail && !dryRun {
+		flagErrorf("-dry-run-detail requires -dry-run")
+		os.Exit(1)
+	}
+	if pendingOnly && pruneOnly {
+		flagErrorf("-pending-only cannot be combined with -prune-source-only")
+		os.Exit(1)
+	}
+	if retryFromReport != "" && watch {
+		flagErrorf("-retry-from-report cannot be combined with -watch")
+		os.Exit(1)
+	}
+	if chunkHistory < 0 {
+		flagErrorf("-chunk-history must not be negative")
+		os.Exit(1)
+	}
+	if chunkHistory > 0 && (dryRun || pruneOnly || reconcile || from == "helm2") {
+		flagErrorf("-chunk-history cannot be combined with -dry-run, -prune-source-only, -reconcile or -from helm2")
+		os.Exit(1)
+	}
+	if reconcile && (pruneOnly || forceDelete) {
+		flagErrorf("-reconcile cannot be combined with -prune-source-only or -force-delete")
+		os.Exit(1)
+	}
+	if cleanupOrphans && keepSource {
+		flagErrorf("-cleanup-orphans cannot be combined with -keep-source")
+		os.Exit(1)
+	}
+	if forceDelete && !yes {
+		flagErrorf("-force-delete deletes source revisions without checking the existing target copy, confirm with -yes")
+		os.Exit(1)
+	}
+	if resumeOnConflict && (forceDelete || reconcile || pruneOnly) {
+		flagErrorf("-resume-on-conflict cannot be combined with -force-delete, -reconcile or -prune-source-only")
+		os.Exit(1)
+	}
+	if forceDelete && keepSource {
+		flagErrorf("-force-delete cannot be combined with -keep-source")
+		os.Exit(1)
+	}
+	if pruneOlderThan > 0 && keepSource {
+		flagErrorf("-prune-older-than cannot be combined with -keep-source")
+		os.Exit(1)
+	}
+	if secretType == "" {
+		flagErrorf("-secret-type must not be empty")
+		os.Exit(1)
+	}
+	if parallelism < 1 || maxNamespaces < 1 {
+		flagErrorf("-parallelism and -max-concurrent-namespaces must be at least 1")
+		os.Exit(1)
+	}
+	if maxFailures < 0 {
+		flagErrorf("-max-failures must not be negative")
+		os.Exit(1)
+	}
+	if latestOnly && (keepSource || pruneOnly || reconcile || chunkHistory > 0 || planFile != "" || sinceVersion > 0 || from == "helm2") {
+		flagErrorf("-latest-only cannot be combined with -keep-source, -prune-source-only, -reconcile, -chunk-history, -plan-file, -since-version or -from helm2")
+		os.Exit(1)
+	}
+	if latestOnly && !dryRun && !yes {
+		flagErrorf("-latest-only deletes the history of every release from the source, confirm with -yes")
+		os.Exit(1)
+	}
+	if retryBudget < 0 {
+		flagErrorf("-retry-budget must not be negative")
+		os.Exit(1)
+	}
+	if countOnly && subcommands != "preflight" {
+		flagErrorf("-count-only only applies to the preflight subprogram")
+		os.Exit(1)
+	}
+	if historyOrder != "ascending" && historyOrder != "descending" {
+		flagErrorf("-history-order must be ascending or descending")
+		os.Exit(1)
+	}
+	if deleteBatchSize < 0 {
+		flagErrorf("-delete-batch-size must not be negative")
+		os.Exit(1)
+	}
+	var err error
+	sourceLabels, err = kblabels.ConvertSelectorToLabelsMap(sourceSelector)
 	if err != nil {
-		return nil, err
+		flagErrorf("invalid -source-selector: %s", err)
+		os.Exit(1)
 	}
-	clientset, err := kubernetes.NewForConfig(kubecfg)
+	if annotationSelector != "" {
+		if os.Getenv("HELM_DRIVER") == "memory" || from == "helm2" || watch || retryFromReport != "" || planFile != "" {
+			flagErrorf("-annotation-selector requires ConfigMaps or Secrets as source and cannot be combined with -watch, -retry-from-report or -plan-file")
+			os.Exit(1)
+		}
+		annotationMatcher, err = kblabels.Parse(annotationSelector)
+		if err != nil {
+			flagErrorf("invalid -annotation-selector: %s", err)
+			os.Exit(1)
+		}
+	}
+	if namespaceSelector != "" {
+		_, err = kblabels.Parse(namespaceSelector)
+		if err != nil {
+			flagErrorf("invalid -namespace-selector: %s", err)
+			os.Exit(1)
+		}
+	}
+	if outputTemplate != "" {
+		if output == "json" {
+			flagErrorf("-output-template cannot be combined with -output json")
+			os.Exit(1)
+		}
+		resultTemplate, err = template.New("output").Parse(outputTemplate)
+		if err != nil {
+			flagErrorf("invalid -output-template: %s", err)
+			os.Exit(1)
+		}
+	}
+	targetLabelMap, targetAnnotationMap, err = parseTargetMetadata(targetLabels, targetAnnotations)
 	if err != nil {
-		return nil, err
+		flagErrorf("%s", err)
+		os.Exit(1)
 	}
-	var cfg action.Configuration
-	err = cfg.Init(kube.GetConfig(kubeconfig, "", ""), namespace, os.Getenv("HELM_DRIVER"), func(format string, v ...interface{}) {
-		fmt.Printf("%s\n", fmt.Sprintf(format, v...))
-	})
+	if len(targetLabelMap)+len(targetAnnotationMap) > 0 {
+		if to == "" || kubeDriverName(to) == "" {
+			flagErrorf("-target-label and -target-annotation require -to configmap or -to secret")
+			os.Exit(1)
+		}
+		infof("adding %s to every storage object created in the target", describeTargetMetadata())
+	}
+	excludeMatchers, err = parseMatchers(excludes)
 	if err != nil {
-		return nil, err
+		flagErrorf("invalid -exclude: %s", err)
+		os.Exit(1)
 	}
-	return &Migrator{
-		clientset: clientset,
-		actionCfg: &cfg,
-	}, nil
-}
-
-func (m *Migrator) migrateRelease(releaseName string, namespace string) error {
-	var helmStorage *storage.Storage
-	switch to {
-	case "configmap", "configmaps":
-		helmStorage = storage.Init(driver.NewConfigMaps(m.clientset.CoreV1().ConfigMaps(namespace)))
-	case "secret", "secrets":
-		helmStorage = storage.Init(driver.NewSecrets(m.clientset.CoreV1().Secrets(namespace)))
-	default:
-		return fmt.Errorf("unknown resource type %s", to)
+	if includeNamespaces != "" {
+		includeNamespaceRegex, err = regexp.Compile(includeNamespaces)
+		if err != nil {
+			flagErrorf("invalid -include-namespaces: %s", err)
+			os.Exit(1)
+		}
 	}
-	histCmd := action.NewHistory(m.actionCfg)
-	histCmd.Max = maxHist
-	hist, err := histCmd.Run(releaseName)
+	if excludeNamespaces != "" {
+		excludeNamespaceRegex, err = regexp.Compile(excludeNamespaces)
+		if err != nil {
+			flagErrorf("invalid -exclude-namespaces: %s", err)
+			os.Exit(1)
+		}
+	}
+	if retryFromReport != "" {
+		failedReleases, err = loadFailedReleases(retryFromReport)
+		if err != nil {
+			flagErrorf("invalid -retry-from-report: %s", err)
+			os.Exit(1)
+		}
+		infof("retrying %d failed releases from %s", len(failedReleases), retryFromReport)
+	}
+	if planFile != "" {
+		plan, planOrder, err = loadPlan(planFile)
+		if err != nil {
+			flagErrorf("invalid -plan-file: %s", err)
+			os.Exit(1)
+		}
+	}
+	if requireContext && contexts == "" {
+		flagErrorf("-require-context is set, select the kube context explicitly with -contexts")
+		os.Exit(1)
+	}
+	kubeContexts, err := resolveContexts()
 	if err != nil {
-		return err
+		flagErrorf("%s", err)
+		os.Exit(1)
 	}
-	failed := false
-	for _, release := range hist {
-		err = helmStorage.Create(release)
+	if targetContext != "" {
+		err = checkTargetContext(kubeContexts)
 		if err != nil {
-			failed = true
-			fmt.Printf("failed to migrate release %s version %d,: %s\n", releaseName, release.Version, err)
-			continue
+			flagErrorf("%s", err)
+			os.Exit(1)
+		}
+	}
+	if serveAddr != "" || reportInterval > 0 {
+		progress = newProgressTracker()
+	}
+	stopReporting := func() {}
+	if reportInterval > 0 {
+		stopReporting = reportProgress(reportInterval)
+	}
+	stopServer := func() {}
+	if serveAddr != "" {
+		stopServer, err = serveProgress(serveAddr)
+		if err != nil {
+			errorf(ErrorRecord{Operation: "serve"}, "failed to start -serve: %s", err)
+			os.Exit(1)
+		}
+	}
+	total := Summary{Type: "summary"}
+	failed := false
+	for _, kubeContext := range kubeContexts {
+		if len(kubeContexts) > 1 {
+			infof("migrating releases in context %s", kubeContext)
 		}
-		_, err = m.actionCfg.Releases.Delete(releaseName, release.Version)
+		summary, err := runContext(kubeContext, subcommands)
 		if err != nil {
+			errorf(ErrorRecord{Operation: subcommands}, "%s", err)
 			failed = true
-			fmt.Printf("failed to delete release %s version %d: %s\n", releaseName, release.Version, err)
-			continue
 		}
-		fmt.Printf("migrated release %s version %d\n", releaseName, release.Version)
+		if len(kubeContexts) > 1 && !readOnlySubcommands[subcommands] && !summaryOnly {
+			summary.Context = kubeContext
+			writeSummary(summary)
+		}
+		total.merge(summary)
 	}
+	if !readOnlySubcommands[subcommands] {
+		slices.SortFunc(total.Namespaces, func(a, b *NamespaceSummary) int {
+			return cmp.Or(strings.Compare(a.Context, b.Context), strings.Compare(a.Namespace, b.Namespace))
+		})
+		line := fmt.Sprintf("total: %s", total.ReleaseCounts)
+		if output == "json" {
+			writeJSON(total)
+		} else if summaryOnly {
+			fmt.Println(line)
+		} else if len(kubeContexts) > 1 {
+			logf("%s", line)
+		}
+		if output != "json" && len(total.Namespaces) > 1 {
+			for _, ns := range total.Namespaces {
+				scope := ns.Namespace
+				if ns.Context != "" {
+					scope = ns.Context + "/" + ns.Namespace
+				}
+				infof("namespace %s: %s in %s", scope, ns.ReleaseCounts, time.Duration(ns.DurationSeconds*float64(time.Second)).Round(time.Millisecond))
+			}
+		}
+	}
+	if summaryOnly && total.Failed > 0 {
+		failed = true
+	}
+	if webhookURL != "" {
+		postWebhook(WebhookPayload{
+			Summary:         total,
+			Contexts:        kubeContexts,
+			DurationSeconds: time.Since(startedAt).Seconds(),
+			Success:         !failed,
+		})
+	}
+	stopReporting()
+	stopServer()
 	if failed {
-		return fmt.Errorf("failed to migrate release %s", releaseName)
+		os.Exit(1)
 	}
-	return nil
 }
 
-func (m *Migrator) migrateNamespace(namespace string) error {
-	releases, err := action.NewList(m.actionCfg).Run()
+// runContext runs a subcommand against the cluster of a single kube context
+// and returns the summary of all processed releases.
+func runContext(kubeContext string, subcommand string) (Summary, error) {
+	migrator, err := NewMigrator(kubeconfig, kubeContext, namespace)
 	if err != nil {
-		return err
+		return Summary{}, err
 	}
-	for _, release := range releases {
-		if release.Namespace == namespace {
-			err = m.migrateRelease(release.Name, namespace)
-			if err != nil {
-				fmt.Println(err)
-				continue
-			}
+	defer migrator.Close()
+	if expectCluster != "" {
+		err = migrator.checkClusterFingerprint()
+		if err != nil {
+			return migrator.summary, err
 		}
 	}
-	return nil
+	if subcommand == "namespace" && namespace == "all" {
+		err = migrator.checkNamespaceAll()
+		if err != nil {
+			return migrator.summary, err
+		}
+		subcommand = "all"
+	}
+	migrator.printBanner(subcommand)
+	if from == "helm2" {
+		switch subcommand {
+		case "release":
+			err = migrator.migrateHelm2(flag.Arg(1), "")
+		case "namespace":
+			err = migrator.migrateHelm2("", namespace)
+		case "all":
+			err = migrator.migrateHelm2("", "")
+		}
+		return migrator.summary, err
+	}
+	switch subcommand {
+	case "release":
+		err = migrator.checkMaxDelete([]*release.Release{{Name: flag.Arg(1), Namespace: namespace}})
+		if err == nil {
+			err = migrator.migrateRelease(flag.Arg(1), namespace)
+		}
+	case "namespace":
+		if watch {
+			err = migrator.watchReleases(namespace)
+		} else if retryFromReport != "" {
+			err = migrator.retryFailed(namespace)
+		} else if planFile != "" {
+			err = migrator.executePlan(namespace)
+		} else {
+			err = migrator.migrateNamespace(namespace)
+		}
+	case "all":
+		if watch {
+			err = migrator.watchReleases(metav1.NamespaceAll)
+		} else if retryFromReport != "" {
+			err = migrator.retryFailed(metav1.NamespaceAll)
+		} else if planFile != "" {
+			err = migrator.executePlan(metav1.NamespaceAll)
+		} else {
+			err = migrator.migrateAll()
+		}
+	case "report":
+		err = migrator.printReport(flag.Arg(1) == "all")
+	case "verify":
+		err = migrator.verifyReleases(flag.Arg(1) == "all")
+	case "drift":
+		err = migrator.reportDrift(flag.Arg(1) == "all")
+	case "repair-duplicates":
+		err = migrator.repairDuplicates(flag.Arg(1) == "all")
+	case "relocate":
+		err = migrator.relocateRelease(flag.Arg(1))
+	case "inspect":
+		err = migrator.inspectRelease(flag.Arg(1), revision)
+	case "get":
+		err = migrator.getRelease(flag.Arg(1), revision)
+	case "preflight":
+		scope := namespace
+		if flag.Arg(1) == "all" {
+			scope = metav1.NamespaceAll
+		}
+		if countOnly {
+			err = migrator.countRecords(scope)
+		} else {
+			err = migrator.preflight(scope)
+		}
+	}
+	if dryRun && output != "json" {
+		printSizes(migrator.summary)
+	}
+	return migrator.summary, err
 }
 
-func (m *Migrator) migrateAll() error {
-	listCmd := action.NewList(m.actionCfg)
-	listCmd.AllNamespaces = true
-	releases, err := listCmd.Run()
+// resolveContexts returns the kube contexts selected with -contexts. The
+// empty string stands for the kubeconfig's current context.
+func resolveContexts() ([]string, error) {
+	switch contexts {
+	case "":
+		return []string{""}, nil
+	case "all":
+		rawCfg, err := loadKubeconfig()
+		if err != nil {
+			return nil, err
+		}
+		names := make([]string, 0, len(rawCfg.Contexts))
+		for name := range rawCfg.Contexts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names, nil
+	default:
+		return strings.Split(contexts, ","), nil
+	}
+}
+
+// checkTargetContext makes sure that -target-context is combined with a single
+// source context and that both exist in the kubeconfig.
+func checkTargetContext(kubeContexts []string) error {
+	if len(kubeContexts) != 1 {
+		return errors.New("-target-context requires a single source context")
+	}
+	switch flag.Arg(0) {
+	case "repair-duplicates", "inspect", "get":
+		return fmt.Errorf("-target-context is not supported for the %s subprogram", flag.Arg(0))
+	}
+	if os.Getenv("HELM_DRIVER") == "memory" || to == "memory" || from == "helm2" {
+		return errors.New("-target-context cannot be combined with the memory driver or -from helm2")
+	}
+	rawCfg, err := loadKubeconfig()
 	if err != nil {
 		return err
 	}
-	for _, release := range releases {
-		err = m.migrateRelease(release.Name, release.Namespace)
+	for _, name := range []string{kubeContexts[0], targetContext} {
+		if name != "" && rawCfg.Contexts[name] == nil {
+			return fmt.Errorf("context %s does not exist in the kubeconfig", name)
+		}
+	}
+	if kubeContexts[0] == "" && rawCfg.Contexts[rawCfg.CurrentContext] == nil {
+		return errors.New("the current context of the kubeconfig does not exist")
+	}
+	return nil
+}
+
+type Migrator struct {
+	restConfig  *rest.Config
+	clientset   *kubernetes.Clientset
+	actionCfg   *action.Configuration
+	kubeContext string
+
+	// the cluster of the target driver, which only differs from clientset
+	// with -target-context
+	targetConfig    *rest.Config
+	targetClientset *kubernetes.Clientset
+
+	// guards summary, the memory drivers, goneNamespaces, the -delete-phase
+	// queues and the -ordered-output buffers against concurrent
+	// migrations
+	mutex   sync.Mutex
+	summary Summary
+
+	// namespaces found to be deleted during the run, see namespaceGone
+	goneNamespaces map[string]bool
+
+	// the auxiliary storage objects by release name of the namespaces of the
+	// running passes of migrateReleases, see auxiliary.go
+	auxiliaryMutex       sync.Mutex
+	auxiliaryByNamespace map[string]map[string][]string
+
+	// source revisions and post-hooks queued with -delete-phase, see
+	// deletephase.go
+	deferredDeletes map[string][]*release.Release
+	deferredHooks   map[string][]deferredHook
+	abortedDeletes  map[string]bool
+
+	// the results buffered with -ordered-output, see ordered.go
+	orderedBuffers map[string]*bytes.Buffer
+	orderedDone    map[string]bool
+	orderedFlushed int
+
+	// only set for -to memory, see memory.go
+	memoryTarget          *driver.Memory
+	memoryTargetNamespace string
+	// the current namespace of the driver in m.actionCfg with the memory
+	// driver as source
+	memorySourceNamespace string
+}
+
+func NewMigrator(kubeconfig string, kubeContext string, namespace string) (*Migrator, error) {
+	if os.Getenv("HELM_DRIVER") == "memory" {
+		releases, err := loadMemoryReleases()
 		if err != nil {
-			fmt.Println(err)
-			continue
+			return nil, err
 		}
+		return newMemoryMigrator(namespace, releases)
+	}
+	kubecfg, err := buildConfig(kubeconfig, kubeContext)
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(kubecfg)
+	if err != nil {
+		return nil, err
+	}
+	sourceDriver := os.Getenv("HELM_DRIVER")
+	switch from {
+	case "auto":
+		sourceDriver, err = autoSourceDriver()
+		if err != nil {
+			return nil, err
+		}
+	case "configmap", "configmaps", "secret", "secrets":
+		sourceDriver = from
+	}
+	var cfg action.Configuration
+	getter := kube.GetConfig(kubeconfig, kubeContext, "")
+	getter.WrapConfigFn = wrapConfig
+	if name := kubeDriverName(sourceDriver); name != "" {
+		// build the source storage on our own clientset like the target,
+		// instead of leaving the choice to cfg.Init
+		kubeClient := kube.New(getter)
+		kubeClient.Log = debugf
+		cfg = action.Configuration{
+			RESTClientGetter: getter,
+			KubeClient:       kubeClient,
+			Releases:         storage.Init(newKubeDriver(clientset, name, namespace)),
+			Log:              debugf,
+		}
+	} else {
+		err = cfg.Init(getter, namespace, sourceDriver, debugf)
+		if err != nil {
+			return nil, err
+		}
+	}
+	m := NewMigratorWithClients(kubecfg, clientset, &cfg)
+	m.kubeContext = kubeContext
+	if targetContext != "" {
+		m.targetConfig, err = buildConfig(kubeconfig, targetContext)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure -target-context: %w", err)
+		}
+		m.targetClientset, err = kubernetes.NewForConfig(m.targetConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure -target-context: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// NewMigratorWithClients returns a Migrator on clients that the caller already
+// built, e.g. instrumented or cached ones, instead of building them from a
+// kubeconfig like NewMigrator. The target is in the same cluster as the
+// source. The storage of actionCfg is the source driver; for ConfigMaps and
+// Secrets, it is re-created on clientset for each namespace.
+func NewMigratorWithClients(restConfig *rest.Config, clientset *kubernetes.Clientset, actionCfg *action.Configuration) *Migrator {
+	return &Migrator{
+		restConfig:      restConfig,
+		clientset:       clientset,
+		actionCfg:       actionCfg,
+		targetConfig:    restConfig,
+		targetClientset: clientset,
+	}
+}
+
+// checkNamespaceAll makes sure that "-namespace all", which is shorthand for
+// the all subprogram, does not refer to an existing namespace called "all".
+func (m *Migrator) checkNamespaceAll() error {
+	if m.clientset == nil {
+		return nil
+	}
+	_, err := m.clientset.CoreV1().Namespaces().Get(context.Background(), "all", metav1.GetOptions{})
+	if err == nil {
+		return errors.New(`-namespace all is ambiguous because a namespace called "all" exists, use the all subprogram to migrate all namespaces`)
+	}
+	if apierrors.IsNotFound(err) || apierrors.IsForbidden(err) {
+		return nil
+	}
+	return err
+}
+
+// Close releases the connections held by the Migrator, which must not be used
+// afterwards. Helm's SQL driver does not expose its database handle, so a SQL
+// source driver keeps its connections until the process exits.
+func (m *Migrator) Close() error {
+	if m.clientset == nil {
+		return nil
+	}
+	client, ok := m.clientset.CoreV1().RESTClient().(*rest.RESTClient)
+	if ok && client.Client != nil {
+		client.Client.CloseIdleConnections()
+	}
+	return nil
+}
+
+// printBanner shows which cluster, scope and drivers a run is about to operate
+// on, so that operators notice before anything happens to the wrong cluster.
+func (m *Migrator) printBanner(subcommand string) {
+	if verbosity < levelInfo {
+		return
+	}
+	kubeContext := m.kubeContext
+	if kubeContext == "" {
+		rawCfg, err := loadKubeconfig()
+		if err == nil {
+			kubeContext = rawCfg.CurrentContext
+		}
+	}
+	scope := "namespace " + namespace
+	if targetNamespace != "" {
+		scope += " to namespace " + targetNamespace
+	}
+	if subcommand == "all" {
+		scope = "all namespaces"
+	}
+	mode := ""
+	if dryRun {
+		mode = " (dry-run)"
+	}
+	if m.restConfig == nil {
+		logf("no cluster (in memory), %s, from %s to %s%s", scope, m.actionCfg.Releases.Name(), to, mode)
+		return
+	}
+	if targetContext != "" {
+		mode = fmt.Sprintf(" in cluster %s (context %s)", m.targetConfig.Host, targetContext) + mode
+	}
+	logf("cluster %s (context %s), %s, from %s to %s%s", m.restConfig.Host, kubeContext, scope, m.actionCfg.Releases.Name(), to, mode)
+}
+
+// buildConfig returns the REST config for a kube context, or for the
+// kubeconfig's current context if kubeContext is empty. Deferred loading
+// resolves credentials like kubectl does, including exec credential plugins
+// and the in-cluster config.
+func buildConfig(kubeconfig string, kubeContext string) (*rest.Config, error) {
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: kubeContext}
+	cfg, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules(kubeconfig), overrides).ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	return wrapConfig(cfg), nil
+}
+
+// wrapConfig applies -trace-api and -read-only to a rest config.
+func wrapConfig(cfg *rest.Config) *rest.Config {
+	return readOnlyConfig(traceConfig(cfg))
+}
+
+// loadingRules returns the rules kubectl uses to find the kubeconfig: the
+// -kubeconfig file if given, otherwise the files in $KUBECONFIG merged in
+// order, or ~/.kube/config.
+func loadingRules(kubeconfig string) *clientcmd.ClientConfigLoadingRules {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+	return rules
+}
+
+// loadKubeconfig returns the merged kubeconfig found by loadingRules.
+func loadKubeconfig() (*clientcmdapi.Config, error) {
+	return loadingRules(kubeconfig).Load()
+}
+
+// targetNamespaceFor returns the namespace of the target driver for releases
+// read from a source namespace, which differs only with -target-namespace.
+func targetNamespaceFor(sourceNS string) string {
+	if targetNamespace != "" && sourceNS == namespace {
+		return targetNamespace
+	}
+	return sourceNS
+}
+
+// migrateRelease migrates a release from the source driver in sourceNS to the
+// target driver in the corresponding target namespace.
+func (m *Migrator) migrateRelease(releaseName string, sourceNS string) (err error) {
+	targetNS := targetNamespaceFor(sourceNS)
+	result := &ReleaseResult{Type: "release", Context: m.kubeContext, Release: releaseName, Namespace: sourceNS, Status: "migrated", started: time.Now()}
+	if targetNS != sourceNS {
+		result.TargetNamespace = targetNS
+	}
+	defer func() {
+		if err != nil && m.namespaceGone(sourceNS) {
+			result.warn("skipping because namespace %s was deleted: %s", sourceNS, err)
+			result.Status = "skipped"
+			err = nil
+		}
+		if err != nil && deletePhase {
+			m.abortDeletePhase(sourceNS)
+		}
+		m.report(result, err)
+		m.emitEvent(result)
+	}()
+	progress.start(sourceNS, releaseName)
+	helmStorage, err := m.targetStorage(targetNS)
+	if err != nil {
+		return err
+	}
+	if decodeCheck {
+		problems, err := m.checkPayloads(releaseName, sourceNS)
+		if err != nil {
+			return fmt.Errorf("failed to check payloads of release %s: %w", releaseName, err)
+		}
+		if len(problems) > 0 {
+			for _, problem := range problems {
+				result.warn("%s", problem)
+			}
+			result.Status = "skipped"
+			return nil
+		}
+	}
+	// with -chunk-history, hist only holds the latest revision until the
+	// others are fetched in chunks of versions
+	var hist []*release.Release
+	var versions []int
+	if entry, ok := plan[planKey(m.kubeContext, sourceNS, releaseName)]; ok {
+		hist, err = m.plannedHistory(entry)
+	} else if chunkHistory > 0 {
+		versions, hist, err = m.chunkedHistory(releaseName, sourceNS)
+	} else if latestOnly {
+		hist, err = m.storedHistory(releaseName, sourceNS)
+	} else {
+		hist, err = m.releaseHistory(releaseName, sourceNS)
+	}
+	if from == "auto" && errors.Is(err, driver.ErrReleaseNotFound) {
+		hist, err = nil, nil
+	}
+	if errors.Is(err, driver.ErrReleaseNotFound) {
+		return fmt.Errorf("release %s not found in namespace %s of the %s driver", releaseName, sourceNS, m.actionCfg.Releases.Name())
+	}
+	if err != nil {
+		return err
+	}
+	if from == "auto" {
+		reason, err := m.autoSkipReason(helmStorage, releaseName, len(hist))
+		if err != nil {
+			return err
+		}
+		if reason != "" {
+			result.warn("%s", reason)
+			result.Status = "skipped"
+			return nil
+		}
+	}
+	if len(hist) == 0 {
+		// a release given by name must not look like a successful no-op
+		msg := "no revisions of the release match -owner, -source-selector and -since-version"
+		if flag.Arg(0) == "release" || flag.Arg(0) == "relocate" {
+			return fmt.Errorf("release %s: %s", releaseName, msg)
+		}
+		result.warn("%s", msg)
+		result.Status = "skipped"
+		return nil
+	}
+	err = checkInfo(hist)
+	if err != nil {
+		return fmt.Errorf("release %s: %w", releaseName, err)
+	}
+	// with -latest-only, the older revisions are deleted from the source
+	// without being migrated
+	var discarded []*release.Release
+	if latestOnly {
+		discarded, hist = hist[:len(hist)-1], hist[len(hist)-1:]
+	}
+	if onlyLatestDeployed {
+		latest := hist[len(hist)-1]
+		if latest.Info == nil || latest.Info.Status != release.StatusDeployed {
+			result.warn("skipping because the latest version %d is not deployed", latest.Version)
+			result.Status = "skipped"
+			return nil
+		}
+		hist = hist[len(hist)-1:]
+		if versions != nil {
+			versions = versions[len(versions)-1:]
+		}
+	}
+	if latest := hist[len(hist)-1]; minAge > 0 && latest.Info != nil {
+		if age := time.Since(latest.Info.LastDeployed.Time); age < minAge {
+			result.warn("skipping because the latest version %d was deployed %s ago, less than -min-age", latest.Version, age.Round(time.Second))
+			result.Status = "skipped"
+			return nil
+		}
+	}
+	if validateChart {
+		problems := invalidCharts(hist)
+		if len(problems) > 0 {
+			for _, problem := range problems {
+				result.warn("%s", problem)
+			}
+			result.Status = "skipped"
+			return nil
+		}
+	}
+	auxiliary, err := m.auxiliaryObjects(releaseName, sourceNS)
+	if err != nil {
+		return fmt.Errorf("failed to list storage objects of release %s: %w", releaseName, err)
+	}
+	for _, name := range auxiliary {
+		result.warn("storage object %s is labeled as part of the release but is not one of its revisions, it is not migrated", name)
+	}
+	metadata, err := chartMetadata(hist[len(hist)-1])
+	if err != nil {
+		result.warn("%s, migrating the stored record as is", err)
+	} else {
+		result.Chart = metadata.Name + "-" + metadata.Version
+	}
+	if dryRun {
+		result.Status = "dry-run"
+		err = diffRelease(result, hist, helmStorage)
+		if err == nil && len(discarded) > 0 {
+			result.Delete = append(revisionVersions(discarded), result.Delete...)
+		}
+		return err
+	}
+	if pruneOnly {
+		result.Status = "pruned"
+		return m.pruneRelease(result, hist, helmStorage)
+	}
+	if reconcile {
+		result.Status = "reconciled"
+		return m.reconcileRelease(result, hist, helmStorage, targetNS)
+	}
+	existing, err := helmStorage.History(releaseName)
+	if err != nil && !errors.Is(err, driver.ErrReleaseNotFound) {
+		return err
+	}
+	if targetLatest, sourceLatest := latestVersion(existing), latestVersion(hist); targetLatest > sourceLatest {
+		warnf("skipping release %s: target already has version %d, source only has up to version %d", releaseName, targetLatest, sourceLatest)
+		result.Status = "skipped"
+		return nil
+	}
+	if keepSource || deletePhase {
+		result.Status = "copied"
+	}
+	revisions := len(hist) + len(discarded)
+	if versions != nil {
+		revisions = len(versions)
+	}
+	infof("migrating release %s (%d revisions)", releaseName, revisions)
+	failed := false
+	latest := 0
+	var pending []int
+	batches := 0
+	deletePending := func() {
+		if len(pending) == 0 {
+			return
+		}
+		if batches > 0 && deleteBatchSize > 0 {
+			time.Sleep(deleteBatchPause)
+		}
+		batches++
+		if deleteGrace > 0 {
+			observable := m.observableInTarget(result, helmStorage, pending)
+			failed = failed || len(observable) < len(pending)
+			pending = observable
+		}
+		for i, err := range m.deleteBatch(helmStorage, releaseName, sourceNS, pending) {
+			if err != nil {
+				failed = true
+				errorf(ErrorRecord{Release: releaseName, Namespace: sourceNS, Version: pending[i], Operation: "delete"}, "failed to delete release %s version %d: %s", releaseName, pending[i], err)
+				result.FailedVersions = append(result.FailedVersions, pending[i])
+				continue
+			}
+			infof("migrated release %s version %d", releaseName, pending[i])
+			result.Versions = append(result.Versions, pending[i])
+			latest = max(latest, pending[i])
+		}
+		pending = nil
+	}
+	for chunk, err := range m.historyChunks(releaseName, sourceNS, hist, versions) {
+		if err != nil {
+			failed = true
+			errorf(ErrorRecord{Release: releaseName, Namespace: sourceNS, Operation: "get"}, "failed to read history of release %s: %s", releaseName, err)
+			break
+		}
+		for _, release := range chunk {
+			if isExpired(release) {
+				_, err = m.sourceStorage(sourceNS).Delete(releaseName, release.Version)
+				if err != nil {
+					failed = true
+					errorf(ErrorRecord{Release: releaseName, Namespace: sourceNS, Version: release.Version, Operation: "prune"}, "failed to prune release %s version %d: %s", releaseName, release.Version, err)
+					result.FailedVersions = append(result.FailedVersions, release.Version)
+					continue
+				}
+				infof("pruned release %s version %d, last deployed %s", releaseName, release.Version, release.Info.LastDeployed.Format(time.RFC3339))
+				result.PrunedVersions = append(result.PrunedVersions, release.Version)
+				continue
+			}
+			created := relocated(release, targetNS)
+			var sourceHash string
+			if strictVerify {
+				sourceHash, err = releaseHash(created)
+				if err != nil {
+					failed = true
+					errorf(ErrorRecord{Release: releaseName, Namespace: sourceNS, Version: release.Version, Operation: "hash"}, "failed to hash release %s version %d: %s", releaseName, release.Version, err)
+					result.FailedVersions = append(result.FailedVersions, release.Version)
+					continue
+				}
+			}
+			err = m.createRelease(helmStorage, targetNS, sourceNS, created)
+			if forceDelete && errors.Is(err, driver.ErrReleaseExists) {
+				warnf("release %s version %d already exists in target, deleting it from source anyway", releaseName, release.Version)
+				err = nil
+			}
+			if resumeOnConflict && errors.Is(err, driver.ErrReleaseExists) {
+				err = resumeConflict(helmStorage, created)
+			}
+			if err != nil {
+				failed = true
+				errorf(ErrorRecord{Release: releaseName, Namespace: targetNS, Version: release.Version, Operation: createOperation(err)}, "failed to migrate release %s version %d: %s%s", releaseName, release.Version, err, failedManifestDetail(release))
+				result.FailedVersions = append(result.FailedVersions, release.Version)
+				continue
+			}
+			if strictVerify {
+				err = checkIntegrity(result, helmStorage, sourceHash, release.Version)
+				if err != nil {
+					failed = true
+					errorf(ErrorRecord{Release: releaseName, Namespace: targetNS, Version: release.Version, Operation: "verify"}, "not deleting release %s version %d from source: %s%s", releaseName, release.Version, err, failedManifestDetail(release))
+					result.FailedVersions = append(result.FailedVersions, release.Version)
+					continue
+				}
+			}
+			if deletePhase {
+				m.deferDelete(sourceNS, release)
+				infof("copied release %s version %d, deleting it from the source once namespace %s is copied", releaseName, release.Version, sourceNS)
+				result.Versions = append(result.Versions, release.Version)
+				latest = max(latest, release.Version)
+				continue
+			}
+			if keepSource {
+				infof("copied release %s version %d", releaseName, release.Version)
+				result.Versions = append(result.Versions, release.Version)
+				latest = max(latest, release.Version)
+				continue
+			}
+			pending = append(pending, release.Version)
+			if len(pending) >= max(deleteBatchSize, 1) {
+				deletePending()
+			}
+		}
+	}
+	deletePending()
+	if len(discarded) > 0 && !failed {
+		failed = m.discardRevisions(result, discarded)
+	}
+	if helmCheck && !failed && len(result.Versions) > 0 {
+		err = m.checkHelmReadable(helmStorage, releaseName, result.Versions)
+		if err != nil {
+			failed = true
+			errorf(ErrorRecord{Release: releaseName, Namespace: targetNS, Operation: "helm-check"}, "Helm cannot read migrated release %s from target: %s", releaseName, err)
+		}
+	}
+	infof("release %s: %d revisions %s, %d failed, %d pruned, %d skipped", releaseName, len(result.Versions), result.Status, len(result.FailedVersions), len(result.PrunedVersions), revisions-len(result.Versions)-len(result.FailedVersions)-len(result.PrunedVersions))
+	if failed {
+		return fmt.Errorf("failed to migrate release %s", releaseName)
+	}
+	if postHook != "" && latest > 0 {
+		if deletePhase {
+			m.deferHook(sourceNS, deferredHook{Release: releaseName, Namespace: targetNS, Version: latest})
+			return nil
+		}
+		return runReleaseHook(releaseName, targetNS, latest)
+	}
+	return nil
+}
+
+// observableInTarget waits for -delete-grace and returns those of the created
+// versions that can be read back from the target. The others are marked as
+// failed, so that their source records are kept.
+func (m *Migrator) observableInTarget(result *ReleaseResult, helmStorage *storage.Storage, versions []int) []int {
+	time.Sleep(deleteGrace)
+	observable := make([]int, 0, len(versions))
+	for _, version := range versions {
+		_, err := helmStorage.Get(result.Release, version)
+		if err != nil {
+			errorf(ErrorRecord{Release: result.Release, Namespace: result.Namespace, Version: version, Operation: "delete"}, "not deleting release %s version %d from source: not readable in target after %s: %s", result.Release, version, deleteGrace, err)
+			result.FailedVersions = append(result.FailedVersions, version)
+			continue
+		}
+		observable = append(observable, version)
+	}
+	return observable
+}
+
+// targetStorage returns the storage of the -to driver in a namespace.
+func (m *Migrator) targetStorage(namespace string) (*storage.Storage, error) {
+	var d driver.Driver
+	switch {
+	case to == "memory":
+		d = m.memoryTargetStorage(namespace)
+	case m.clientset == nil:
+		return nil, fmt.Errorf("releases from the memory driver can only be migrated to memory")
+	case to == "configmap" || to == "configmaps":
+		d = driver.NewConfigMaps(m.targetClientset.CoreV1().ConfigMaps(namespace))
+	case to == "secret" || to == "secrets":
+		d = driver.NewSecrets(m.targetClientset.CoreV1().Secrets(namespace))
+	default:
+		return nil, fmt.Errorf("unknown resource type %s", to)
+	}
+	if targetStoragePrefix != "" {
+		d = &prefixedDriver{Driver: d, prefix: targetStoragePrefix}
+	}
+	if readOnly {
+		d = &readOnlyDriver{Driver: d}
+	}
+	return storage.Init(d), nil
+}
+
+// sourceStorage returns the storage of the source driver in a namespace.
+func (m *Migrator) sourceStorage(namespace string) *storage.Storage {
+	var d driver.Driver
+	switch name := m.actionCfg.Releases.Name(); name {
+	case driver.ConfigMapsDriverName, driver.SecretsDriverName:
+		d = newKubeDriver(m.clientset, name, namespace)
+	case driver.MemoryDriverName:
+		d = m.memorySourceStorage(namespace).Driver
+	default:
+		d = m.actionCfg.Releases.Driver
+	}
+	d = &infoDefaultingDriver{Driver: d}
+	if readOnly {
+		d = &readOnlyDriver{Driver: d}
+	}
+	return storage.Init(d)
+}
+
+// kubeDriverName returns the name of the Kubernetes-backed driver selected by
+// a value of $HELM_DRIVER, -from or -to, or an empty string for other drivers.
+// Like for Helm, an empty value selects Secrets.
+func kubeDriverName(value string) string {
+	switch value {
+	case "configmap", "configmaps":
+		return driver.ConfigMapsDriverName
+	case "", "secret", "secrets":
+		return driver.SecretsDriverName
+	default:
+		return ""
+	}
+}
+
+// newKubeDriver returns the Kubernetes-backed driver with the given name in a
+// namespace of a cluster.
+func newKubeDriver(clientset *kubernetes.Clientset, name string, namespace string) driver.Driver {
+	if name == driver.ConfigMapsDriverName {
+		cfgmaps := driver.NewConfigMaps(clientset.CoreV1().ConfigMaps(namespace))
+		cfgmaps.Log = debugf
+		return cfgmaps
+	}
+	secrets := driver.NewSecrets(clientset.CoreV1().Secrets(namespace))
+	secrets.Log = debugf
+	return secrets
+}
+
+// releaseHistory returns the most recent -max revisions of a release from the
+// source driver that are newer than -since-version, sorted by version.
+func (m *Migrator) releaseHistory(releaseName string, sourceNS string) ([]*release.Release, error) {
+	records, err := m.storedHistory(releaseName, sourceNS)
+	if err != nil {
+		return nil, err
+	}
+	return limitHistory(records), nil
+}
+
+// limitHistory returns the revisions selected by -since-version and -max from
+// a history sorted by version.
+func limitHistory(records []*release.Release) []*release.Release {
+	var hist []*release.Release
+	for _, release := range records {
+		if release.Version > sinceVersion {
+			hist = append(hist, release)
+		}
+	}
+	if maxHist > 0 && len(hist) > maxHist {
+		hist = hist[len(hist)-maxHist:]
+	}
+	return hist
+}
+
+// storedHistory returns all revisions of a release in a namespace of the
+// source driver, sorted by version. The namespace is always the release's
+// own, as the all subprogram reads releases from many namespaces. Records
+// with the release's name but an unexpected owner label were not written by
+// Helm and are skipped with a warning.
+func (m *Migrator) storedHistory(releaseName string, sourceNS string) ([]*release.Release, error) {
+	query := map[string]string{sourceLabelKeys.Name: releaseName}
+	for k, v := range sourceLabels {
+		query[k] = v
+	}
+	records, err := m.sourceStorage(sourceNS).Query(query)
+	if err != nil {
+		return nil, err
+	}
+	var hist []*release.Release
+	for _, release := range records {
+		if release.Labels[sourceLabelKeys.Owner] != owner {
+			logf("skipping release %s version %d: owner label is %q, expected %q", releaseName, release.Version, release.Labels[sourceLabelKeys.Owner], owner)
+			continue
+		}
+		normalizeLabels(release)
+		hist = append(hist, release)
+	}
+	releaseutil.SortByRevision(hist)
+	return hist, nil
+}
+
+// pruneRelease deletes the source revisions of a release once all of them are
+// present and identical in the target. Releases which are only partially
+// migrated are left untouched.
+func (m *Migrator) pruneRelease(result *ReleaseResult, hist []*release.Release, helmStorage *storage.Storage) error {
+	releaseName := result.Release
+	complete := true
+	for _, release := range hist {
+		state, err := targetState(helmStorage, release)
+		if err != nil {
+			return err
+		}
+		if state != "identical" {
+			complete = false
+			logf("release %s version %d is %s in target", releaseName, release.Version, state)
+		}
+	}
+	if !complete {
+		return fmt.Errorf("not pruning release %s: not fully present in target", releaseName)
+	}
+	failed := false
+	for _, release := range hist {
+		err := m.deleteSource(helmStorage, releaseName, result.Namespace, release.Version)
+		if err != nil {
+			failed = true
+			errorf(ErrorRecord{Release: releaseName, Namespace: result.Namespace, Version: release.Version, Operation: "delete"}, "failed to delete release %s version %d: %s", releaseName, release.Version, err)
+			result.FailedVersions = append(result.FailedVersions, release.Version)
+			continue
+		}
+		infof("pruned release %s version %d", releaseName, release.Version)
+		result.Versions = append(result.Versions, release.Version)
+	}
+	if failed {
+		return fmt.Errorf("failed to prune release %s", releaseName)
+	}
+	return nil
+}
+
+// sameRelease reports whether two revisions have the same stored content.
+// Storage labels like createdAt are not part of the comparison.
+func sameRelease(a, b *release.Release) bool {
+	aJSON, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	bJSON, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(aJSON, bJSON)
+}
+
+// deleteBatch deletes a batch of migrated revisions from the source driver
+// concurrently and returns the error for each of them.
+func (m *Migrator) deleteBatch(helmStorage *storage.Storage, releaseName string, sourceNS string, versions []int) []error {
+	errs := make([]error, len(versions))
+	var wg sync.WaitGroup
+	for i, version := range versions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = m.deleteSource(helmStorage, releaseName, sourceNS, version)
+		}()
+	}
+	wg.Wait()
+	return errs
+}
+
+// deleteSource removes a migrated revision from the source driver. The Helm
+// drivers delete storage objects without preconditions, so for ConfigMaps and
+// Secrets the object is read first, compared with its copy in the target and
+// only deleted if its resource version did not change in the meantime. A
+// revision that was changed after it was migrated is kept. When the delete
+// conflicts with a concurrent change, the comparison and the delete are
+// retried.
+func (m *Migrator) deleteSource(helmStorage *storage.Storage, releaseName string, sourceNS string, version int) error {
+	resource, err := driverResource(m.actionCfg.Releases.Name())
+	if err != nil || m.clientset == nil {
+		_, err := m.sourceStorage(sourceNS).Delete(releaseName, version)
+		return err
+	}
+	for attempt := 0; ; attempt++ {
+		err := m.deleteUnchanged(helmStorage, resource, sourceNS, releaseName, version)
+		if err == nil || !apierrors.IsConflict(err) || attempt >= maxRetries || !takeRetry() {
+			return err
+		}
+		infof("conflict deleting release %s version %d, retrying: %s", releaseName, version, err)
+	}
+}
+
+// deleteUnchanged deletes the source storage object of a revision with its
+// current resource version as precondition, unless it differs from the copy
+// of the revision in the target. With -force-delete, the copy is not compared.
+func (m *Migrator) deleteUnchanged(helmStorage *storage.Storage, resource string, sourceNS string, releaseName string, version int) error {
+	key := releaseKey(releaseName, version)
+	var data, resourceVersion string
+	switch resource {
+	case "configmaps":
+		cm, err := m.clientset.CoreV1().ConfigMaps(sourceNS).Get(context.Background(), key, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		data, resourceVersion = cm.Data["release"], cm.ResourceVersion
+	case "secrets":
+		secret, err := m.clientset.CoreV1().Secrets(sourceNS).Get(context.Background(), key, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		data, resourceVersion = string(secret.Data["release"]), secret.ResourceVersion
+	}
+	// -force-delete deletes source revisions regardless of their target copy
+	if !forceDelete {
+		current, err := decodeRelease(data)
+		if err != nil {
+			return fmt.Errorf("failed to decode the source copy: %w", err)
+		}
+		migrated, err := helmStorage.Get(releaseName, version)
+		if err != nil {
+			return fmt.Errorf("failed to read the target copy: %w", err)
+		}
+		if !sameContent(relocated(current, migrated.Namespace), migrated) {
+			return fmt.Errorf("release %s version %d was changed in the source after it was migrated, not deleting it", releaseName, version)
+		}
+	}
+	opts := metav1.DeleteOptions{Preconditions: &metav1.Preconditions{ResourceVersion: &resourceVersion}}
+	if resource == "configmaps" {
+		return m.clientset.CoreV1().ConfigMaps(sourceNS).Delete(context.Background(), key, opts)
+	}
+	return m.clientset.CoreV1().Secrets(sourceNS).Delete(context.Background(), key, opts)
+}
+
+// discardRevisions deletes the revisions older than the migrated one from the
+// source for -latest-only, and reports whether any delete failed.
+func (m *Migrator) discardRevisions(result *ReleaseResult, discarded []*release.Release) bool {
+	failed := false
+	for _, rls := range discarded {
+		_, err := m.sourceStorage(result.Namespace).Delete(rls.Name, rls.Version)
+		if err != nil {
+			failed = true
+			errorf(ErrorRecord{Release: rls.Name, Namespace: result.Namespace, Version: rls.Version, Operation: "prune"}, "failed to discard release %s version %d: %s", rls.Name, rls.Version, err)
+			result.FailedVersions = append(result.FailedVersions, rls.Version)
+			continue
+		}
+		infof("discarded release %s version %d", rls.Name, rls.Version)
+		result.PrunedVersions = append(result.PrunedVersions, rls.Version)
+	}
+	return failed
+}
+
+// chartMetadata returns the chart metadata of a revision. Records written by
+// old Helm versions may lack parts of it, which must not fail the migration
+// since the stored record can be copied regardless.
+func chartMetadata(rls *release.Release) (*chart.Metadata, error) {
+	if rls.Chart == nil || rls.Chart.Metadata == nil {
+		return nil, fmt.Errorf("chart metadata of version %d could not be decoded", rls.Version)
+	}
+	return rls.Chart.Metadata, nil
+}
+
+// invalidCharts validates the chart of each revision with Helm's chart
+// validation and returns one message per revision whose chart is invalid.
+// With -chunk-history, only the latest revision is at hand and validated.
+func invalidCharts(hist []*release.Release) []string {
+	var problems []string
+	for _, rls := range hist {
+		if rls.Chart == nil {
+			problems = append(problems, fmt.Sprintf("version %d has no chart", rls.Version))
+			continue
+		}
+		err := rls.Chart.Validate()
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("chart of version %d is invalid: %s", rls.Version, err))
+		}
+	}
+	return problems
+}
+
+// isExpired reports whether a revision falls under -prune-older-than and is
+// deleted from the source instead of being migrated. The deployed revision is
+// always migrated.
+func isExpired(rls *release.Release) bool {
+	if pruneOlderThan <= 0 || rls.Info == nil || rls.Info.Status == release.StatusDeployed {
+		return false
+	}
+	return time.Since(rls.Info.LastDeployed.Time) > pruneOlderThan
+}
+
+// latestVersion returns the highest version among the given revisions, or 0
+// if there are none.
+func latestVersion(hist []*release.Release) int {
+	latest := 0
+	for _, release := range hist {
+		latest = max(latest, release.Version)
+	}
+	return latest
+}
+
+// diffRelease prints how many revisions of a release exist in the source and
+// the target driver and how many of them a migration would create, along with
+// the size of their encoded payloads. It also records the plan for the
+// release in the result, i.e. which versions a migration would create in the
+// target and delete from the source, with warnings about anything that would
+// make it fail or race with Helm.
+func diffRelease(result *ReleaseResult, hist []*release.Release, helmStorage *storage.Storage) error {
+	releaseName := result.Release
+	existing, err := helmStorage.History(releaseName)
+	if err != nil && !errors.Is(err, driver.ErrReleaseNotFound) {
+		return err
+	}
+	inTarget := make(map[int]*release.Release, len(existing))
+	for _, release := range existing {
+		inTarget[release.Version] = release
+	}
+	result.TargetDriver = helmStorage.Name()
+	result.Create, result.Delete = []int{}, []int{}
+	missing, size := 0, 0
+	for _, release := range hist {
+		switch {
+		case isExpired(release):
+			result.Delete = append(result.Delete, release.Version)
+			continue
+		case inTarget[release.Version] != nil:
+			result.warn("version %d already exists in target", release.Version)
+			if dryRunDetail {
+				err := printReleaseDiff(release, inTarget[release.Version])
+				if err != nil {
+					return fmt.Errorf("failed to diff release %s version %d: %w", releaseName, release.Version, err)
+				}
+			}
+			if forceDelete || resumeOnConflict && !keepSource && sameRelease(relocated(release, inTarget[release.Version].Namespace), inTarget[release.Version]) {
+				result.Delete = append(result.Delete, release.Version)
+			}
+			continue
+		}
+		data, err := encodeRelease(release)
+		if err != nil {
+			return fmt.Errorf("failed to encode release %s version %d: %w", releaseName, release.Version, err)
+		}
+		if len(data) > maxStorageObjectSize {
+			result.warn("version %d has %d bytes, more than fit into a storage object", release.Version, len(data))
+		}
+		missing++
+		size += len(data)
+		result.Create = append(result.Create, release.Version)
+		if !keepSource {
+			result.Delete = append(result.Delete, release.Version)
+		}
+	}
+	if latest := hist[len(hist)-1]; latest.Info != nil && latest.Info.Status.IsPending() {
+		result.warn("latest version %d is %s, a Helm operation may still be running", latest.Version, latest.Info.Status)
+	}
+	logf("release %s: %d revisions in source, %d in target, %d to create (%d bytes)", releaseName, len(hist), len(existing), missing, size)
+	sourceCount, targetCount := len(hist), len(existing)
+	result.SourceRevisions = &sourceCount
+	result.TargetRevisions = &targetCount
+	result.ToCreate = &missing
+	result.Size = &size
+	return nil
+}
+
+// listReleases returns the latest revision of each release in -namespace of
+// the source driver, or in all namespaces. Helm's list action ignores
+// AllNamespaces and only sees the namespace of its storage, so the storage is
+// scoped explicitly instead of relying on the namespace m.actionCfg was
+// initialized with.
+func (m *Migrator) listReleases(allNamespaces bool) ([]*release.Release, error) {
+	scope := namespace
+	if allNamespaces {
+		scope = metav1.NamespaceAll
+	}
+	var releases []*release.Release
+	var err error
+	if onlyOrphaned {
+		releases, err = listOrphanedReleases(m.sourceConfig(scope), allNamespaces)
+	} else {
+		releases, err = listReleasesFrom(m.sourceConfig(scope), allNamespaces)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m.filterByAnnotations(releases, scope)
+}
+
+// sourceConfig returns a copy of m.actionCfg whose storage is the source
+// driver in a namespace, or in all namespaces if namespace is empty.
+func (m *Migrator) sourceConfig(namespace string) *action.Configuration {
+	cfg := *m.actionCfg
+	cfg.Releases = m.sourceStorage(namespace)
+	return &cfg
+}
+
+// listTargetReleases returns the latest revision of each release in the
+// target driver for a source namespace, or for all namespaces if namespace is
+// empty. The releases are returned with the source namespace, which differs
+// from theirs with -target-namespace.
+func (m *Migrator) listTargetReleases(namespace string) ([]*release.Release, error) {
+	targetStorage, err := m.targetStorage(targetNamespaceFor(namespace))
+	if err != nil {
+		return nil, err
+	}
+	cfg := *m.actionCfg
+	cfg.Releases = targetStorage
+	listCmd := action.NewList(&cfg)
+	listCmd.AllNamespaces = namespace == metav1.NamespaceAll
+	releases, err := listCmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list releases in %s: %w", targetStorage.Name(), err)
+	}
+	if namespace == metav1.NamespaceAll {
+		return releases, nil
+	}
+	for i, rls := range releases {
+		copied := *rls
+		copied.Namespace = namespace
+		releases[i] = &copied
+	}
+	return releases, nil
+}
+
+// listNamespaceReleases lists the releases of each of the given namespaces
+// separately, which only needs namespace-scoped permissions.
+func (m *Migrator) listNamespaceReleases(namespaces []string) ([]*release.Release, error) {
+	var releases []*release.Release
+	for _, namespace := range namespaces {
+		namespaceReleases, err := listReleasesFrom(m.sourceConfig(namespace), false)
+		if err == nil {
+			namespaceReleases, err = m.filterByAnnotations(namespaceReleases, namespace)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list releases in namespace %s: %w", namespace, err)
+		}
+		releases = append(releases, namespaceReleases...)
+	}
+	return releases, nil
+}
+
+// listReleasesFrom returns the latest revision of each release in the storage
+// of an action configuration. With -source-selector, the storage objects are
+// filtered by the API server instead of being listed in full. The same query
+// is used when the source has non-standard label keys, which Helm's listing
+// does not support.
+func listReleasesFrom(cfg *action.Configuration, allNamespaces bool) ([]*release.Release, error) {
+	if len(sourceLabels) == 0 && sourceLabelKeys.isStandard() {
+		listCmd := action.NewList(cfg)
+		listCmd.AllNamespaces = allNamespaces
+		return listCmd.Run()
+	}
+	return queryLatestReleases(cfg)
+}
+
+// queryLatestReleases returns the latest revision of each release whose
+// storage objects have the -owner label and match -source-selector, without
+// the filtering of Helm's list action.
+func queryLatestReleases(cfg *action.Configuration) ([]*release.Release, error) {
+	query := map[string]string{sourceLabelKeys.Owner: owner}
+	for k, v := range sourceLabels {
+		query[k] = v
+	}
+	records, err := cfg.Releases.Driver.Query(query)
+	if errors.Is(err, driver.ErrReleaseNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	latest := make(map[string]*release.Release)
+	for _, release := range records {
+		key := release.Namespace + "/" + release.Name
+		if latest[key] == nil || latest[key].Version < release.Version {
+			latest[key] = release
+		}
+	}
+	releases := make([]*release.Release, 0, len(latest))
+	for _, release := range latest {
+		releases = append(releases, release)
+	}
+	releaseutil.SortByName(releases)
+	return releases, nil
+}
+
+// labeledNamespaces returns the namespaces matching -namespace-selector.
+func (m *Migrator) labeledNamespaces() (map[string]bool, error) {
+	if m.clientset == nil {
+		return nil, errors.New("-namespace-selector needs a cluster to list namespaces")
+	}
+	list, err := m.clientset.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{LabelSelector: namespaceSelector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list namespaces matching -namespace-selector: %w", err)
+	}
+	labeled := make(map[string]bool, len(list.Items))
+	names := make([]string, 0, len(list.Items))
+	for _, ns := range list.Items {
+		labeled[ns.Name] = true
+		names = append(names, ns.Name)
+	}
+	slices.Sort(names)
+	logf("%d namespaces match -namespace-selector %s: %s", len(names), namespaceSelector, strings.Join(names, ", "))
+	return labeled, nil
+}
+
+// migrateReleases migrates releases with up to -parallelism of them at once.
+// Failures are logged and reported, but do not stop the other releases until
+// -max-failures is reached.
+func (m *Migrator) migrateReleases(releases []*release.Release) error {
+	if pendingOnly {
+		var err error
+		releases, err = m.skipMigrated(releases)
+		if err != nil {
+			return err
+		}
+	}
+	progress.addTotal(len(releases))
+	defer m.beginAuxiliaryPass(releases)()
+	semaphore := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for _, release := range releases {
+		semaphore <- struct{}{}
+		err := m.checkMaxFailures()
+		if err != nil {
+			wg.Wait()
+			return err
+		}
+		if m.knownGone(release.Namespace) {
+			<-semaphore
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			err := m.migrateRelease(release.Name, release.Namespace)
+			if err != nil {
+				errorf(ErrorRecord{Release: release.Name, Namespace: release.Namespace, Operation: "migrate"}, "%s", err)
+			}
+		}()
+	}
+	wg.Wait()
+	return m.checkMaxFailures()
+}
+
+// namespaceGone reports whether a namespace was deleted during the run. The
+// first time, a warning is printed and the namespace is recorded in the
+// summary, and its remaining releases are skipped by migrateReleases instead
+// of each failing.
+func (m *Migrator) namespaceGone(namespace string) bool {
+	if m.knownGone(namespace) {
+		return true
+	}
+	if m.clientset == nil {
+		return false
+	}
+	_, err := m.clientset.CoreV1().Namespaces().Get(context.Background(), namespace, metav1.GetOptions{})
+	if !apierrors.IsNotFound(err) {
+		return false
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if !m.goneNamespaces[namespace] {
+		if m.goneNamespaces == nil {
+			m.goneNamespaces = make(map[string]bool)
+		}
+		m.goneNamespaces[namespace] = true
+		m.summary.SkippedNamespaces = append(m.summary.SkippedNamespaces, namespace)
+		warnf("namespace %s was deleted during the run, skipping its releases", namespace)
+	}
+	return true
+}
+
+// knownGone reports whether namespaceGone already found a namespace deleted.
+func (m *Migrator) knownGone(namespace string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.goneNamespaces[namespace]
+}
+
+// checkMaxFailures returns an error once -max-failures releases have failed.
+func (m *Migrator) checkMaxFailures() error {
+	if maxFailures == 0 {
+		return nil
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.summary.Failed < maxFailures {
+		return nil
+	}
+	return fmt.Errorf("aborting after %d failed releases, %d releases were processed", m.summary.Failed, m.summary.Releases)
+}
+
+// checkMaxDelete counts the source revisions that migrating the given
+// releases deletes at most, and fails if they exceed -max-delete, unless -yes
+// is set. The count is computed up front so that a filter that accidentally
+// selects everything is caught before the first revision is deleted.
+func (m *Migrator) checkMaxDelete(releases []*release.Release) error {
+	if !deleteLimited() {
+		return nil
+	}
+	count := 0
+	for _, rls := range releases {
+		var hist []*release.Release
+		var err error
+		if latestOnly {
+			hist, err = m.storedHistory(rls.Name, rls.Namespace)
+		} else {
+			hist, err = m.releaseHistory(rls.Name, rls.Namespace)
+		}
+		if errors.Is(err, driver.ErrReleaseNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to count the revisions of release %s for -max-delete: %w", rls.Name, err)
+		}
+		count += len(hist)
+	}
+	return checkDeleteCount(count)
+}
+
+// deleteLimited reports whether -max-delete applies to this run.
+func deleteLimited() bool {
+	return maxDelete > 0 && !yes && !dryRun && !keepSource
+}
+
+// checkDeleteCount fails if a run that deletes up to count source revisions
+// exceeds -max-delete.
+func checkDeleteCount(count int) error {
+	if count > maxDelete {
+		return fmt.Errorf("this run would delete up to %d source revisions, more than -max-delete %d; raise the limit or confirm with -yes", count, maxDelete)
+	}
+	infof("this run deletes up to %d source revisions, within -max-delete %d", count, maxDelete)
+	return nil
+}
+
+// skipExcluded drops the releases matched by -exclude.
+func skipExcluded(releases []*release.Release) []*release.Release {
+	return slices.DeleteFunc(releases, func(release *release.Release) bool {
+		if matchesAny(excludeMatchers, release.Name) {
+			logf("excluding release %s/%s", release.Namespace, release.Name)
+			return true
+		}
+		return false
+	})
+}
+
+// skipMigrated removes the releases whose revisions selected for migration
+// are all identical in the target already, for -pending-only.
+func (m *Migrator) skipMigrated(releases []*release.Release) ([]*release.Release, error) {
+	skipped := 0
+	var pending []*release.Release
+	for _, rls := range releases {
+		helmStorage, err := m.targetStorage(targetNamespaceFor(rls.Namespace))
+		if err != nil {
+			return nil, err
+		}
+		hist, err := m.releaseHistory(rls.Name, rls.Namespace)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get history of release %s: %w", rls.Name, err)
+		}
+		migrated := len(hist) > 0
+		for _, revision := range hist {
+			state, err := targetState(helmStorage, revision)
+			if err != nil {
+				return nil, fmt.Errorf("failed to check release %s version %d in target: %w", rls.Name, revision.Version, err)
+			}
+			if state != "identical" {
+				migrated = false
+				break
+			}
+		}
+		if migrated {
+			infof("skipping release %s/%s: already migrated", rls.Namespace, rls.Name)
+			skipped++
+			continue
+		}
+		pending = append(pending, rls)
+	}
+	logf("skipping %d releases already in the target, %d releases pending", skipped, len(pending))
+	return pending, nil
+}
+
+func (m *Migrator) migrateNamespace(namespace string) error {
+	releases, err := m.listReleases(false)
+	if err != nil {
+		return err
+	}
+	releases = slices.DeleteFunc(releases, func(release *release.Release) bool {
+		return release.Namespace != namespace
+	})
+	releases, err = m.unionTargetReleases(releases, namespace)
+	if err != nil {
+		return err
+	}
+	releases = skipExcluded(releases)
+	err = m.checkMaxDelete(releases)
+	if err != nil {
+		return err
+	}
+	err = m.migrateReleases(releases)
+	if err != nil {
+		return err
+	}
+	if deletePhase {
+		err = m.runDeletePhase(namespace)
+		if err != nil {
+			return err
+		}
+	}
+	if cleanupOrphans {
+		return m.cleanupOrphans(namespace)
+	}
+	return nil
+}
+
+// listAllReleases lists the source releases in all namespaces, or in the
+// namespaces from -namespaces one by one if listing all of them is forbidden.
+func (m *Migrator) listAllReleases() ([]*release.Release, error) {
+	releases, err := m.listReleases(true)
+	if apierrors.IsForbidden(err) && len(namespaceList) > 0 {
+		logf("not allowed to list releases in all namespaces, listing the %d namespaces from -namespaces one by one: %s", len(namespaceList), err)
+		releases, err = m.listNamespaceReleases(namespaceList)
+	}
+	return releases, err
+}
+
+// restrictNamespaces removes the releases outside of the namespaces from
+// -namespaces and -namespace-selector.
+func (m *Migrator) restrictNamespaces(releases []*release.Release) ([]*release.Release, error) {
+	if len(namespaceList) > 0 {
+		releases = slices.DeleteFunc(releases, func(release *release.Release) bool {
+			return !slices.Contains(namespaceList, release.Namespace)
+		})
+	}
+	if namespaceSelector != "" {
+		labeled, err := m.labeledNamespaces()
+		if err != nil {
+			return nil, err
+		}
+		releases = slices.DeleteFunc(releases, func(release *release.Release) bool {
+			return !labeled[release.Namespace]
+		})
+	}
+	return releases, nil
+}
+
+func (m *Migrator) migrateAll() error {
+	releases, err := m.listAllReleases()
+	if err == nil {
+		releases, err = m.unionTargetReleases(releases, metav1.NamespaceAll)
+	}
+	if err == nil {
+		releases, err = m.restrictNamespaces(releases)
+	}
+	if err != nil {
+		return err
+	}
+	byNamespace := make(map[string][]*release.Release)
+	for _, release := range skipExcluded(releases) {
+		byNamespace[release.Namespace] = append(byNamespace[release.Namespace], release)
+	}
+	namespaces := slices.Sorted(maps.Keys(byNamespace))
+	if includeNamespaceRegex != nil || excludeNamespaceRegex != nil {
+		var skipped []string
+		namespaces = slices.DeleteFunc(namespaces, func(namespace string) bool {
+			if namespaceSelected(namespace) {
+				return false
+			}
+			skipped = append(skipped, namespace)
+			return true
+		})
+		logf("migrating %d namespaces matching -include-namespaces and -exclude-namespaces: %s", len(namespaces), strings.Join(namespaces, ", "))
+		if len(skipped) > 0 {
+			infof("skipping %d namespaces: %s", len(skipped), strings.Join(skipped, ", "))
+		}
+	}
+	var selected []*release.Release
+	for _, namespace := range namespaces {
+		selected = append(selected, byNamespace[namespace]...)
+	}
+	err = m.checkMaxDelete(selected)
+	if err != nil {
+		return err
+	}
+	concurrency := maxNamespaces
+	if to == "memory" {
+		concurrency = 1
+	}
+	semaphore := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	var deleteErrs []error
+	for _, namespace := range namespaces {
+		semaphore <- struct{}{}
+		if m.checkMaxFailures() != nil {
+			<-semaphore
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			defer m.namespaceDone(namespaces, namespace)
+			if m.namespaceGone(namespace) {
+				return
+			}
+			// an abort is reported once below, after all namespaces stopped
+			err := m.migrateReleases(byNamespace[namespace])
+			if err == nil && deletePhase {
+				err = m.runDeletePhase(namespace)
+				if err != nil {
+					errorf(ErrorRecord{Namespace: namespace, Operation: "delete"}, "%s", err)
+					m.mutex.Lock()
+					deleteErrs = append(deleteErrs, err)
+					m.mutex.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	// print the namespaces left out after an abort, if they follow one that
+	// was never started
+	for _, namespace := range namespaces {
+		m.namespaceDone(namespaces, namespace)
+	}
+	err = m.checkMaxFailures()
+	if err != nil {
+		return err
+	}
+	if cleanupOrphans {
+		for _, namespace := range namespaces {
+			err = m.cleanupOrphans(namespace)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if len(deleteErrs) > 0 {
+		return fmt.Errorf("the delete phase failed in %d namespaces", len(deleteErrs))
 	}
 	return nil
 }
@@ -0,0 +1,670 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kblabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// fakeConfigMaps is a minimal Kubernetes API serving ConfigMaps, with
+// resource versions, delete preconditions, label selectors, metadata-only
+// lists and merge patches of labels. ConfigMaps are keyed by namespace and
+// name.
+type fakeConfigMaps struct {
+	mutex      sync.Mutex
+	configMaps map[string]*corev1.ConfigMap
+	version    int
+	// the number of full and metadata-only lists served
+	lists         int
+	metadataLists int
+	// called after each GET, e.g. to simulate a concurrent change
+	afterGet func(f *fakeConfigMaps, key string)
+	// called before each request, fails it if an error is returned
+	intercept func(f *fakeConfigMaps, r *http.Request) *apierrors.StatusError
+	// the field managers of the ConfigMaps created with POST
+	createdBy []string
+}
+
+func configMapKey(namespace string, name string) string {
+	return namespace + "/" + name
+}
+
+func (f *fakeConfigMaps) put(cm *corev1.ConfigMap) {
+	f.version++
+	cm.ResourceVersion = strconv.Itoa(f.version)
+	f.configMaps[configMapKey(cm.Namespace, cm.Name)] = cm
+}
+
+func (f *fakeConfigMaps) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.intercept != nil {
+		if err := f.intercept(f, r); err != nil {
+			writeStatus(w, err)
+			return
+		}
+	}
+	if r.URL.Path == "/api/v1/configmaps" {
+		f.serveCollection(w, r, metav1.NamespaceAll)
+		return
+	}
+	// /api/v1/namespaces/<namespace>/configmaps[/<name>]
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/namespaces/"), "/")
+	if len(parts) == 1 && r.Method == http.MethodGet {
+		// all namespaces exist
+		writeObject(w, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: parts[0]}})
+		return
+	}
+	if len(parts) < 2 || parts[1] != "configmaps" {
+		writeStatus(w, apierrors.NewNotFound(corev1.Resource("unknown"), r.URL.Path))
+		return
+	}
+	namespace := parts[0]
+	if len(parts) == 2 {
+		f.serveCollection(w, r, namespace)
+		return
+	}
+	key := configMapKey(namespace, parts[2])
+	cm := f.configMaps[key]
+	if cm == nil {
+		writeStatus(w, apierrors.NewNotFound(corev1.Resource("configmaps"), parts[2]))
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeObject(w, cm)
+		if f.afterGet != nil {
+			f.afterGet(f, key)
+		}
+	case http.MethodPatch:
+		var patch corev1.ConfigMap
+		_ = json.NewDecoder(r.Body).Decode(&patch)
+		cm = cm.DeepCopy()
+		for k, v := range patch.Labels {
+			cm.Labels[k] = v
+		}
+		f.put(cm)
+		writeObject(w, cm)
+	case http.MethodDelete:
+		var opts metav1.DeleteOptions
+		_ = json.NewDecoder(r.Body).Decode(&opts)
+		if opts.Preconditions != nil && opts.Preconditions.ResourceVersion != nil && *opts.Preconditions.ResourceVersion != cm.ResourceVersion {
+			writeStatus(w, apierrors.NewConflict(corev1.Resource("configmaps"), cm.Name, nil))
+			return
+		}
+		delete(f.configMaps, key)
+		writeObject(w, &metav1.Status{Status: metav1.StatusSuccess})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeConfigMaps) serveCollection(w http.ResponseWriter, r *http.Request, namespace string) {
+	switch r.Method {
+	case http.MethodGet:
+		selector, err := kblabels.Parse(r.URL.Query().Get("labelSelector"))
+		if err != nil {
+			writeStatus(w, apierrors.NewBadRequest(err.Error()))
+			return
+		}
+		var matching []*corev1.ConfigMap
+		for _, cm := range f.configMaps {
+			if (namespace == metav1.NamespaceAll || cm.Namespace == namespace) && selector.Matches(kblabels.Set(cm.Labels)) {
+				matching = append(matching, cm)
+			}
+		}
+		if strings.Contains(r.Header.Get("Accept"), "as=PartialObjectMetadataList") {
+			f.metadataLists++
+			list := &metav1.PartialObjectMetadataList{TypeMeta: metav1.TypeMeta{Kind: "PartialObjectMetadataList", APIVersion: "meta.k8s.io/v1"}}
+			for _, cm := range matching {
+				list.Items = append(list.Items, metav1.PartialObjectMetadata{TypeMeta: metav1.TypeMeta{Kind: "PartialObjectMetadata", APIVersion: "meta.k8s.io/v1"}, ObjectMeta: cm.ObjectMeta})
+			}
+			writeObject(w, list)
+			return
+		}
+		f.lists++
+		list := &corev1.ConfigMapList{}
+		for _, cm := range matching {
+			list.Items = append(list.Items, *cm)
+		}
+		writeObject(w, list)
+	case http.MethodPost:
+		var cm corev1.ConfigMap
+		_ = json.NewDecoder(r.Body).Decode(&cm)
+		cm.Namespace = namespace
+		if f.configMaps[configMapKey(namespace, cm.Name)] != nil {
+			writeStatus(w, apierrors.NewAlreadyExists(corev1.Resource("configmaps"), cm.Name))
+			return
+		}
+		f.put(&cm)
+		f.createdBy = append(f.createdBy, r.URL.Query().Get("fieldManager"))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(&cm)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// fakeMigrator returns a Migrator whose source and target are the ConfigMaps
+// driver on a fake API.
+func fakeMigrator(t *testing.T, api *fakeConfigMaps) *Migrator {
+	t.Helper()
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	// the fake API only speaks JSON
+	restConfig := &rest.Config{Host: server.URL, ContentConfig: rest.ContentConfig{ContentType: "application/json"}}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := storage.Init(driver.NewConfigMaps(clientset.CoreV1().ConfigMaps("default")))
+	return NewMigratorWithClients(restConfig, clientset, &action.Configuration{Releases: source, KubeClient: &kubefake.PrintingKubeClient{Out: io.Discard}})
+}
+
+func writeObject(w http.ResponseWriter, obj any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(obj)
+}
+
+func writeStatus(w http.ResponseWriter, err *apierrors.StatusError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(int(err.ErrStatus.Code))
+	_ = json.NewEncoder(w).Encode(err.ErrStatus)
+}
+
+// releaseConfigMap returns the storage object of a revision as written by the
+// ConfigMaps driver.
+func releaseConfigMap(t *testing.T, rls *release.Release) *corev1.ConfigMap {
+	t.Helper()
+	data, err := encodeRelease(rls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: releaseKey(rls.Name, rls.Version), Namespace: rls.Namespace, Labels: storageLabels(rls)},
+		Data:       map[string]string{"release": data},
+	}
+}
+
+// useFlags sets the flags that a migration reads to their defaults, except
+// for -to memory and -max 0, for the duration of a test.
+func useFlags(t *testing.T) {
+	t.Helper()
+	oldTo, oldMaxHist, oldOwner, oldOrder, oldRetries, oldNamespace, oldVerbosity := to, maxHist, owner, historyOrder, maxRetries, namespace, verbosity
+	oldParallelism, oldMaxNamespaces := parallelism, maxNamespaces
+	t.Cleanup(func() {
+		to, maxHist, owner, historyOrder, maxRetries, namespace, verbosity = oldTo, oldMaxHist, oldOwner, oldOrder, oldRetries, oldNamespace, oldVerbosity
+		parallelism, maxNamespaces = oldParallelism, oldMaxNamespaces
+	})
+	to, maxHist, owner, historyOrder, maxRetries, namespace, verbosity = "memory", 0, "helm", "ascending", 3, "default", levelWarning
+	parallelism, maxNamespaces = 1, 1
+}
+
+func testRelease(name string, namespace string, version int, status release.Status) *release.Release {
+	return &release.Release{
+		Name:      name,
+		Namespace: namespace,
+		Version:   version,
+		Info:      &release.Info{Status: status},
+		Chart:     &chart.Chart{Metadata: &chart.Metadata{Name: name, Version: "1.0.0"}},
+		Manifest:  "kind: ConfigMap\n",
+	}
+}
+
+func TestDeleteSource(t *testing.T) {
+	useFlags(t)
+
+	changeManifest := func(f *fakeConfigMaps, key string) {
+		rls, _ := decodeRelease(f.configMaps[key].Data["release"])
+		rls.Manifest = "kind: Secret\n"
+		data, _ := encodeRelease(rls)
+		cm := f.configMaps[key].DeepCopy()
+		cm.Data["release"] = data
+		f.put(cm)
+	}
+	touchedOnce := false
+	changeLabelOnce := func(f *fakeConfigMaps, key string) {
+		if touchedOnce {
+			return
+		}
+		touchedOnce = true
+		cm := f.configMaps[key].DeepCopy()
+		cm.Labels["touched"] = "true"
+		f.put(cm)
+	}
+
+	tests := []struct {
+		name        string
+		inSource    bool
+		afterGet    func(f *fakeConfigMaps, name string)
+		wantErr     bool
+		wantDeleted bool
+	}{
+		{name: "unchanged", inSource: true, wantDeleted: true},
+		{name: "already deleted", inSource: false, wantDeleted: true},
+		{name: "conflict without content change", inSource: true, afterGet: changeLabelOnce, wantDeleted: true},
+		{name: "content changed after migration", inSource: true, afterGet: changeManifest, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rls := testRelease("app", "default", 1, release.StatusDeployed)
+			api := &fakeConfigMaps{configMaps: make(map[string]*corev1.ConfigMap), afterGet: tt.afterGet}
+			if tt.inSource {
+				api.put(releaseConfigMap(t, rls))
+			}
+			m := fakeMigrator(t, api)
+			target := storage.Init(driver.NewMemory())
+			err := target.Create(rls)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = m.deleteSource(target, rls.Name, rls.Namespace, rls.Version)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("deleteSource returned %v, want error: %t", err, tt.wantErr)
+			}
+			_, inSource := api.configMaps[configMapKey(rls.Namespace, releaseKey(rls.Name, rls.Version))]
+			if inSource == tt.wantDeleted {
+				t.Errorf("source object exists after deleteSource: %t, want %t", inSource, !tt.wantDeleted)
+			}
+		})
+	}
+}
+
+// versionsOf returns the versions of a release in a storage, sorted.
+func versionsOf(t *testing.T, s *storage.Storage, name string) []int {
+	t.Helper()
+	hist, err := s.History(name)
+	if err != nil && !errors.Is(err, driver.ErrReleaseNotFound) {
+		t.Fatal(err)
+	}
+	var versions []int
+	for _, rls := range hist {
+		versions = append(versions, rls.Version)
+	}
+	sort.Ints(versions)
+	return versions
+}
+
+func TestMigrateReleasesInTwoNamespaces(t *testing.T) {
+	tests := []struct {
+		name   string
+		seeded []*release.Release
+		// versions in the target by namespace and release name
+		want map[string]map[string][]int
+	}{
+		{
+			name: "same release name",
+			seeded: []*release.Release{
+				testRelease("app", "a", 1, release.StatusSuperseded),
+				testRelease("app", "a", 2, release.StatusDeployed),
+				testRelease("app", "b", 1, release.StatusDeployed),
+			},
+			want: map[string]map[string][]int{"a": {"app": {1, 2}}, "b": {"app": {1}}},
+		},
+		{
+			name: "different release names",
+			seeded: []*release.Release{
+				testRelease("web", "a", 1, release.StatusDeployed),
+				testRelease("db", "b", 3, release.StatusDeployed),
+			},
+			want: map[string]map[string][]int{"a": {"web": {1}, "db": nil}, "b": {"db": {3}, "web": nil}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFlags(t)
+			m, err := newMemoryMigrator("a", tt.seeded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			releases, err := m.listReleases(true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, rls := range releases {
+				err := m.migrateRelease(rls.Name, rls.Namespace)
+				if err != nil {
+					t.Fatalf("migrating release %s in namespace %s: %s", rls.Name, rls.Namespace, err)
+				}
+			}
+
+			for namespace, names := range tt.want {
+				target, err := m.targetStorage(namespace)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for name, want := range names {
+					if got := versionsOf(t, target, name); !slices.Equal(got, want) {
+						t.Errorf("target namespace %s has versions %v of release %s, want %v", namespace, got, name, want)
+					}
+					if got := versionsOf(t, m.sourceStorage(namespace), name); len(got) > 0 {
+						t.Errorf("source namespace %s still has versions %v of release %s", namespace, got, name)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestListReleasesAcrossNamespaces(t *testing.T) {
+	seeded := func() []*release.Release {
+		return []*release.Release{
+			testRelease("app", "a", 1, release.StatusSuperseded),
+			testRelease("app", "a", 2, release.StatusDeployed),
+			testRelease("app", "b", 1, release.StatusDeployed),
+			testRelease("db", "c", 4, release.StatusDeployed),
+		}
+	}
+	tests := []struct {
+		name string
+		list func(m *Migrator) ([]*release.Release, error)
+		want []string
+	}{
+		{
+			name: "-namespace only",
+			list: func(m *Migrator) ([]*release.Release, error) { return m.listReleases(false) },
+			want: []string{"a/app.v2"},
+		},
+		{
+			name: "all namespaces",
+			list: func(m *Migrator) ([]*release.Release, error) { return m.listReleases(true) },
+			want: []string{"a/app.v2", "b/app.v1", "c/db.v4"},
+		},
+		{
+			name: "selected namespaces",
+			list: func(m *Migrator) ([]*release.Release, error) { return m.listNamespaceReleases([]string{"b", "c"}) },
+			want: []string{"b/app.v1", "c/db.v4"},
+		},
+		{
+			name: "empty namespace",
+			list: func(m *Migrator) ([]*release.Release, error) { return m.listNamespaceReleases([]string{"d"}) },
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFlags(t)
+			namespace = "a"
+			// start from another namespace than the one that is listed, like
+			// the all subcommand does after migrating a release
+			m, err := newMemoryMigrator("c", seeded())
+			if err != nil {
+				t.Fatal(err)
+			}
+			releases, err := tt.list(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, rls := range releases {
+				got = append(got, fmt.Sprintf("%s/%s.v%d", rls.Namespace, rls.Name, rls.Version))
+			}
+			sort.Strings(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("listed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// writeKubeconfig writes a kubeconfig with a cluster, user and context of
+// the given name to a temporary file and returns its path.
+func writeKubeconfig(t *testing.T, name string, server string, user string) string {
+	t.Helper()
+	content := fmt.Sprintf(`apiVersion: v1
+kind: Config
+current-context: %[1]s
+clusters:
+- name: %[1]s
+  cluster:
+    server: %[2]s
+users:
+- name: %[1]s
+  user:
+%[3]s
+contexts:
+- name: %[1]s
+  context:
+    cluster: %[1]s
+    user: %[1]s
+`, name, server, user)
+	path := filepath.Join(t.TempDir(), name+".yaml")
+	err := os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestKubeconfigMerge(t *testing.T) {
+	const token = "    token: secret"
+	tests := []struct {
+		name        string
+		files       []string
+		kubeconfig  string
+		kubeContext string
+		wantCurrent string
+		wantServer  string
+		wantErr     bool
+	}{
+		{
+			name:        "first file sets the current context",
+			files:       []string{"one", "two"},
+			wantCurrent: "one",
+			wantServer:  "https://one.example.com",
+		},
+		{
+			name:        "context from a later file",
+			files:       []string{"one", "two"},
+			kubeContext: "two",
+			wantCurrent: "one",
+			wantServer:  "https://two.example.com",
+		},
+		{
+			name:        "-kubeconfig replaces $KUBECONFIG",
+			files:       []string{"one", "two"},
+			kubeconfig:  "two",
+			wantCurrent: "two",
+			wantServer:  "https://two.example.com",
+		},
+		{
+			name:        "context only in $KUBECONFIG",
+			files:       []string{"one", "two"},
+			kubeconfig:  "two",
+			kubeContext: "one",
+			wantCurrent: "two",
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths := make(map[string]string)
+			var envPaths []string
+			for _, name := range tt.files {
+				paths[name] = writeKubeconfig(t, name, "https://"+name+".example.com", token)
+				envPaths = append(envPaths, paths[name])
+			}
+			t.Setenv("KUBECONFIG", strings.Join(envPaths, string(filepath.ListSeparator)))
+			oldKubeconfig := kubeconfig
+			kubeconfig = paths[tt.kubeconfig]
+			defer func() { kubeconfig = oldKubeconfig }()
+
+			rawCfg, err := loadKubeconfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rawCfg.CurrentContext != tt.wantCurrent {
+				t.Errorf("current context is %q, want %q", rawCfg.CurrentContext, tt.wantCurrent)
+			}
+			cfg, err := buildConfig(kubeconfig, tt.kubeContext)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildConfig returned %v, want error: %t", err, tt.wantErr)
+			}
+			if err == nil && cfg.Host != tt.wantServer {
+				t.Errorf("config targets %s, want %s", cfg.Host, tt.wantServer)
+			}
+		})
+	}
+}
+
+func TestBuildConfigExecPlugin(t *testing.T) {
+	tests := []struct {
+		name        string
+		user        string
+		wantCommand string
+		wantToken   string
+	}{
+		{
+			name: "exec credential plugin",
+			user: `    exec:
+      apiVersion: client.authentication.k8s.io/v1
+      command: kubelogin
+      args: [get-token]
+      interactiveMode: Never`,
+			wantCommand: "kubelogin",
+		},
+		{
+			name:      "static token",
+			user:      "    token: secret",
+			wantToken: "secret",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeKubeconfig(t, "cluster", "https://cluster.example.com", tt.user)
+			// the plugin is only run when a request is made
+			cfg, err := buildConfig(path, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			command := ""
+			if cfg.ExecProvider != nil {
+				command = cfg.ExecProvider.Command
+			}
+			if command != tt.wantCommand {
+				t.Errorf("exec plugin command is %q, want %q", command, tt.wantCommand)
+			}
+			if cfg.BearerToken != tt.wantToken {
+				t.Errorf("bearer token is %q, want %q", cfg.BearerToken, tt.wantToken)
+			}
+			_, err = kubernetes.NewForConfig(cfg)
+			if err != nil {
+				t.Errorf("cannot build a clientset for the config: %s", err)
+			}
+		})
+	}
+}
+
+func TestMaxDelete(t *testing.T) {
+	app := []*release.Release{{Name: "app", Namespace: "default"}}
+	retry := func(m *Migrator) error {
+		failedReleases = []ReleaseResult{{Release: "app", Namespace: "default"}}
+		return m.retryFailed("default")
+	}
+	executePlan := func(m *Migrator) error {
+		plan = map[string]ReleaseResult{planKey("", "default", "app"): {Release: "app", Namespace: "default", Create: []int{1, 2, 3}}}
+		planOrder = []string{planKey("", "default", "app")}
+		return m.executePlan("default")
+	}
+	relocate := func(m *Migrator) error {
+		targetNamespace = "other"
+		return m.relocateRelease("app")
+	}
+	migrate := func(m *Migrator) error {
+		err := m.checkMaxDelete(app)
+		if err == nil {
+			err = m.migrateRelease("app", "default")
+		}
+		return err
+	}
+	tests := []struct {
+		name      string
+		maxDelete int
+		yes       bool
+		run       func(m *Migrator) error
+		wantErr   bool
+	}{
+		{name: "release within the limit", maxDelete: 3, run: migrate},
+		{name: "release over the limit", maxDelete: 2, run: migrate, wantErr: true},
+		{name: "release over the limit with -yes", maxDelete: 2, yes: true, run: migrate},
+		{name: "-retry-from-report over the limit", maxDelete: 2, run: retry, wantErr: true},
+		{name: "-plan-file over the limit", maxDelete: 2, run: executePlan, wantErr: true},
+		{name: "-plan-file within the limit", maxDelete: 3, run: executePlan},
+		{name: "relocate over the limit", maxDelete: 2, run: relocate, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFlags(t)
+			oldMaxDelete, oldYes, oldFailed, oldPlan, oldOrder, oldTarget := maxDelete, yes, failedReleases, plan, planOrder, targetNamespace
+			defer func() {
+				maxDelete, yes, failedReleases, plan, planOrder, targetNamespace = oldMaxDelete, oldYes, oldFailed, oldPlan, oldOrder, oldTarget
+			}()
+			maxDelete, yes = tt.maxDelete, tt.yes
+			m, err := newMemoryMigrator("default", []*release.Release{
+				testRelease("app", "default", 1, release.StatusSuperseded),
+				testRelease("app", "default", 2, release.StatusSuperseded),
+				testRelease("app", "default", 3, release.StatusDeployed),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = tt.run(m)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("run returned %v, want error: %t", err, tt.wantErr)
+			}
+			remaining := versionsOf(t, m.sourceStorage("default"), "app")
+			if tt.wantErr && len(remaining) != 3 {
+				t.Errorf("aborted run left versions %v in the source, want all of them", remaining)
+			}
+			if !tt.wantErr && len(remaining) != 0 {
+				t.Errorf("run left versions %v in the source, want none", remaining)
+			}
+		})
+	}
+}
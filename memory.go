@@ -0,0 +1,130 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/kube"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	"sigs.k8s.io/yaml"
+)
+
+// The memory driver is not advertised in the usage output. It exists to test
+// migrations without a cluster: with HELM_DRIVER=memory, the source is seeded
+// from the YAML files listed in $HELM_MEMORY_DRIVER_DATA (like the helm CLI
+// does), and -to memory migrates into a second in-memory driver.
+//
+// Neither driver is persisted: the source starts out with just the seeded
+// releases and the target empty in every run, and both are gone when the
+// process exits. Operations that need the target of a previous run, like
+// verify or -prune-source-only, are therefore rejected with -to memory.
+// Within a run, the target is shared by all namespaces and by steps like the
+// verification that follows the creation of revisions with -reconcile.
+
+// newMemoryMigrator returns a Migrator whose source is an in-memory driver
+// holding the given releases.
+func newMemoryMigrator(namespace string, releases []*release.Release) (*Migrator, error) {
+	var cfg action.Configuration
+	err := cfg.Init(kube.GetConfig("", "", ""), namespace, "memory", debugf)
+	if err != nil {
+		return nil, err
+	}
+	cfg.KubeClient = &kubefake.PrintingKubeClient{Out: io.Discard}
+	for _, rls := range releases {
+		if rls.Info == nil {
+			return nil, fmt.Errorf("cannot seed release %s version %d: the memory driver cannot store revisions without release info", rls.Name, rls.Version)
+		}
+		// the memory driver does not report storage labels, so set the
+		// ones the other drivers would report
+		rls.Labels = map[string]string{"name": rls.Name, "owner": "helm"}
+		err := cfg.Releases.Create(rls)
+		if err != nil {
+			return nil, fmt.Errorf("failed to seed release %s version %d: %w", rls.Name, rls.Version, err)
+		}
+	}
+	// creating releases switches the driver to their namespace
+	cfg.Releases.Driver.(*driver.Memory).SetNamespace(namespace)
+	return &Migrator{actionCfg: &cfg, memorySourceNamespace: namespace}, nil
+}
+
+// loadMemoryReleases reads the releases listed in $HELM_MEMORY_DRIVER_DATA.
+func loadMemoryReleases() ([]*release.Release, error) {
+	if os.Getenv("HELM_MEMORY_DRIVER_DATA") == "" {
+		return nil, errors.New("HELM_DRIVER=memory requires $HELM_MEMORY_DRIVER_DATA, the memory driver is not persisted across runs and holds no releases otherwise")
+	}
+	var releases []*release.Release
+	for _, path := range strings.Split(os.Getenv("HELM_MEMORY_DRIVER_DATA"), ":") {
+		if path == "" {
+			continue
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read memory driver data: %w", err)
+		}
+		var fileReleases []*release.Release
+		err = yaml.Unmarshal(b, &fileReleases)
+		if err != nil {
+			return nil, fmt.Errorf("unable to unmarshal memory driver data from %s: %w", path, err)
+		}
+		releases = append(releases, fileReleases...)
+	}
+	return releases, nil
+}
+
+// memoryTargetStorage returns the in-memory target driver for -to memory.
+// The same driver is reused for all namespaces, so that migrated releases
+// stay visible for the lifetime of the Migrator. Because the driver tracks
+// a single current namespace, namespaces are never migrated concurrently into
+// it.
+func (m *Migrator) memoryTargetStorage(namespace string) *driver.Memory {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.memoryTarget == nil {
+		m.memoryTarget = driver.NewMemory()
+	}
+	if m.memoryTargetNamespace != namespace {
+		m.memoryTarget.SetNamespace(namespace)
+		m.memoryTargetNamespace = namespace
+	}
+	return m.memoryTarget
+}
+
+// memorySourceStorage returns the storage of the in-memory source driver
+// switched to a namespace, or to all namespaces if namespace is empty. Like
+// the target, it tracks a single current namespace, which is why releases
+// from memory are never migrated concurrently across namespaces.
+func (m *Migrator) memorySourceStorage(namespace string) *storage.Storage {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.memorySourceNamespace != namespace {
+		m.actionCfg.Releases.Driver.(*driver.Memory).SetNamespace(namespace)
+		m.memorySourceNamespace = namespace
+	}
+	return m.actionCfg.Releases
+}
@@ -0,0 +1,82 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// With -ordered-output, the all subprogram still migrates namespaces
+// concurrently, but the results of each namespace are buffered and printed
+// together with the summary of the namespace, in alphabetical order of the
+// namespaces: a namespace is printed as soon as it and all namespaces before
+// it are done. Progress messages cannot be attributed to a namespace, so
+// -ordered-output implies -quiet.
+
+// resultOutput returns where the result of a release is written. The caller
+// must hold m.mutex.
+func (m *Migrator) resultOutput(namespace string) io.Writer {
+	if !orderedOutput {
+		return os.Stdout
+	}
+	if m.orderedBuffers == nil {
+		m.orderedBuffers = make(map[string]*bytes.Buffer)
+	}
+	if m.orderedBuffers[namespace] == nil {
+		m.orderedBuffers[namespace] = &bytes.Buffer{}
+	}
+	return m.orderedBuffers[namespace]
+}
+
+// namespaceDone marks a namespace of the sorted namespaces of a run as done
+// and prints the buffered results of all namespaces that are next in order.
+func (m *Migrator) namespaceDone(namespaces []string, namespace string) {
+	if !orderedOutput {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.orderedDone == nil {
+		m.orderedDone = make(map[string]bool)
+	}
+	m.orderedDone[namespace] = true
+	for m.orderedFlushed < len(namespaces) && m.orderedDone[namespaces[m.orderedFlushed]] {
+		next := namespaces[m.orderedFlushed]
+		m.orderedFlushed++
+		if buf := m.orderedBuffers[next]; buf != nil {
+			_, err := os.Stdout.Write(buf.Bytes())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to write output: %s\n", err)
+			}
+			delete(m.orderedBuffers, next)
+		}
+		ns := *m.summary.namespace(m.kubeContext, next)
+		if output == "json" {
+			ns.Type = "namespace-summary"
+			writeJSON(ns)
+			continue
+		}
+		fmt.Printf("namespace %s: %s in %s\n", next, ns.ReleaseCounts, time.Duration(ns.DurationSeconds*float64(time.Second)).Round(time.Millisecond))
+	}
+}
@@ -0,0 +1,58 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"slices"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/release"
+)
+
+// listOrphanedReleases returns the latest revision of each release that is
+// stored in the source driver, but not shown by Helm's list action, which
+// only shows releases whose latest revision is deployed or failed. These are
+// left behind by a normal migration, so -only-orphaned migrates them.
+func listOrphanedReleases(cfg *action.Configuration, allNamespaces bool) ([]*release.Release, error) {
+	listCmd := action.NewList(cfg)
+	listCmd.AllNamespaces = allNamespaces
+	listed, err := listCmd.Run()
+	if err != nil {
+		return nil, err
+	}
+	visible := make(map[string]bool, len(listed))
+	for _, rls := range listed {
+		visible[rls.Namespace+"/"+rls.Name] = true
+	}
+	stored, err := queryLatestReleases(cfg)
+	if err != nil {
+		return nil, err
+	}
+	orphaned := slices.DeleteFunc(stored, func(rls *release.Release) bool {
+		return visible[rls.Namespace+"/"+rls.Name]
+	})
+	names := make([]string, 0, len(orphaned))
+	for _, rls := range orphaned {
+		names = append(names, rls.Namespace+"/"+rls.Name)
+	}
+	logf("found %d orphaned releases that helm list does not show: %s", len(orphaned), strings.Join(names, ", "))
+	return orphaned, nil
+}
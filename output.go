@@ -0,0 +1,398 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ReleaseResult is the outcome of processing a single release. In JSON output
+// mode, each result is written as one line to stdout as soon as it is known.
+type ReleaseResult struct {
+	Type      string `json:"type"`
+	Context   string `json:"context,omitempty"`
+	Release   string `json:"release"`
+	Namespace string `json:"namespace"`
+	// only set with -target-namespace
+	TargetNamespace string   `json:"target_namespace,omitempty"`
+	Status          string   `json:"status"`
+	Chart           string   `json:"chart,omitempty"`
+	Versions        []int    `json:"versions,omitempty"`
+	FailedVersions  []int    `json:"failed_versions,omitempty"`
+	PrunedVersions  []int    `json:"pruned_versions,omitempty"`
+	Error           string   `json:"error,omitempty"`
+	Warnings        []string `json:"warnings,omitempty"`
+	// only set with -strict-verify
+	Hashes []RevisionHash `json:"hashes,omitempty"`
+
+	// only set in dry-run mode, where they make up the plan for the release
+	SourceRevisions *int   `json:"source_revisions,omitempty"`
+	TargetRevisions *int   `json:"target_revisions,omitempty"`
+	ToCreate        *int   `json:"to_create,omitempty"`
+	Size            *int   `json:"size,omitempty"`
+	TargetDriver    string `json:"target_driver,omitempty"`
+	Create          []int  `json:"create,omitempty"`
+	Delete          []int  `json:"delete,omitempty"`
+
+	// when the migration of the release started, for the per-namespace
+	// durations of the summary
+	started time.Time
+}
+
+// warn logs a warning about a release and attaches it to the result.
+func (r *ReleaseResult) warn(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	warnf("release %s: %s", r.Release, msg)
+	r.Warnings = append(r.Warnings, msg)
+}
+
+// Summary aggregates the results of a run. It is written as the last line in
+// JSON output mode.
+type Summary struct {
+	Type    string `json:"type"`
+	Context string `json:"context,omitempty"`
+	ReleaseCounts
+
+	// namespace/name of each failed release
+	FailedReleases []string `json:"failed_releases,omitempty"`
+	// namespaces deleted during the run, whose releases were skipped
+	SkippedNamespaces []string `json:"skipped_namespaces,omitempty"`
+	// the results broken down by namespace
+	Namespaces []*NamespaceSummary `json:"namespaces,omitempty"`
+
+	// only set in dry-run mode
+	Size            int            `json:"size,omitempty"`
+	SizeByNamespace map[string]int `json:"size_by_namespace,omitempty"`
+}
+
+// NamespaceSummary aggregates the results of the releases in one namespace.
+// The duration is the time from the start of the first release to the end of
+// the last one, which includes the time spent on other namespaces that were
+// migrated concurrently.
+type NamespaceSummary struct {
+	// only set with -ordered-output, which prints each namespace summary
+	Type      string `json:"type,omitempty"`
+	Context   string `json:"context,omitempty"`
+	Namespace string `json:"namespace"`
+	ReleaseCounts
+	DurationSeconds float64 `json:"duration_seconds,omitempty"`
+
+	started time.Time
+}
+
+// ReleaseCounts counts the releases of a run by their status. Only releases
+// that were moved to the target, i.e. created there and deleted from the
+// source, count as migrated.
+type ReleaseCounts struct {
+	Releases   int `json:"releases"`
+	Migrated   int `json:"migrated"`
+	Copied     int `json:"copied,omitempty"`
+	Reconciled int `json:"reconciled,omitempty"`
+	Pruned     int `json:"pruned,omitempty"`
+	DryRun     int `json:"dry_run,omitempty"`
+	Skipped    int `json:"skipped"`
+	Failed     int `json:"failed"`
+}
+
+func (c *ReleaseCounts) count(status string) {
+	c.Releases++
+	*c.counter(status)++
+}
+
+// uncount takes back a release counted with a status.
+func (c *ReleaseCounts) uncount(status string) {
+	c.Releases--
+	*c.counter(status)--
+}
+
+func (c *ReleaseCounts) counter(status string) *int {
+	switch status {
+	case "failed":
+		return &c.Failed
+	case "skipped":
+		return &c.Skipped
+	case "copied":
+		return &c.Copied
+	case "reconciled":
+		return &c.Reconciled
+	case "pruned":
+		return &c.Pruned
+	case "dry-run":
+		return &c.DryRun
+	default:
+		return &c.Migrated
+	}
+}
+
+func (c *ReleaseCounts) addCounts(other ReleaseCounts) {
+	c.Releases += other.Releases
+	c.Migrated += other.Migrated
+	c.Copied += other.Copied
+	c.Reconciled += other.Reconciled
+	c.Pruned += other.Pruned
+	c.DryRun += other.DryRun
+	c.Skipped += other.Skipped
+	c.Failed += other.Failed
+}
+
+// outcomes describes the counts for the text output, leaving out the
+// statuses that only occur with some flags unless there are any.
+func (c ReleaseCounts) outcomes() string {
+	parts := []string{fmt.Sprintf("%d migrated", c.Migrated)}
+	for _, optional := range []struct {
+		count int
+		name  string
+	}{{c.Copied, "copied"}, {c.Reconciled, "reconciled"}, {c.Pruned, "pruned"}, {c.DryRun, "dry-run"}} {
+		if optional.count > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", optional.count, optional.name))
+		}
+	}
+	parts = append(parts, fmt.Sprintf("%d skipped", c.Skipped), fmt.Sprintf("%d failed", c.Failed))
+	return strings.Join(parts, ", ")
+}
+
+func (c ReleaseCounts) String() string {
+	return fmt.Sprintf("%d releases, %s", c.Releases, c.outcomes())
+}
+
+func (s *Summary) add(result *ReleaseResult) {
+	ns := s.namespace(result.Context, result.Namespace)
+	s.count(result.Status)
+	ns.count(result.Status)
+	if result.Status == "failed" {
+		s.FailedReleases = append(s.FailedReleases, result.Namespace+"/"+result.Release)
+	}
+	if !result.started.IsZero() {
+		if ns.started.IsZero() || result.started.Before(ns.started) {
+			ns.started = result.started
+		}
+		ns.DurationSeconds = time.Since(ns.started).Seconds()
+	}
+	if result.Size != nil {
+		s.addSize(result.Namespace, *result.Size)
+	}
+}
+
+// readd counts a result again that was already added with another status.
+func (s *Summary) readd(result *ReleaseResult, previous string) {
+	s.uncount(previous)
+	s.namespace(result.Context, result.Namespace).uncount(previous)
+	s.add(result)
+}
+
+// namespace returns the summary of a namespace, which is added if needed.
+func (s *Summary) namespace(kubeContext string, namespace string) *NamespaceSummary {
+	i := slices.IndexFunc(s.Namespaces, func(ns *NamespaceSummary) bool {
+		return ns.Context == kubeContext && ns.Namespace == namespace
+	})
+	if i >= 0 {
+		return s.Namespaces[i]
+	}
+	ns := &NamespaceSummary{Context: kubeContext, Namespace: namespace}
+	s.Namespaces = append(s.Namespaces, ns)
+	return ns
+}
+
+func (s *Summary) addSize(namespace string, size int) {
+	if s.SizeByNamespace == nil {
+		s.SizeByNamespace = make(map[string]int)
+	}
+	s.Size += size
+	s.SizeByNamespace[namespace] += size
+}
+
+func (s *Summary) merge(other Summary) {
+	s.addCounts(other.ReleaseCounts)
+	s.FailedReleases = append(s.FailedReleases, other.FailedReleases...)
+	s.SkippedNamespaces = append(s.SkippedNamespaces, other.SkippedNamespaces...)
+	for _, other := range other.Namespaces {
+		ns := s.namespace(other.Context, other.Namespace)
+		ns.addCounts(other.ReleaseCounts)
+		ns.DurationSeconds = max(ns.DurationSeconds, other.DurationSeconds)
+	}
+	for namespace, size := range other.SizeByNamespace {
+		s.addSize(namespace, size)
+	}
+}
+
+// writeSummary emits the summary of a single kube context.
+func writeSummary(s Summary) {
+	if output == "json" {
+		s.Type = "summary"
+		writeJSON(s)
+		return
+	}
+	logf("context %s: %s", s.Context, s.ReleaseCounts)
+}
+
+// printSizes prints the total and per-namespace size of the release payloads
+// a migration would create.
+func printSizes(s Summary) {
+	namespaces := make([]string, 0, len(s.SizeByNamespace))
+	for namespace := range s.SizeByNamespace {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	for _, namespace := range namespaces {
+		logf("namespace %s: %d bytes to create", namespace, s.SizeByNamespace[namespace])
+	}
+	logf("total: %d bytes to create", s.Size)
+}
+
+// logOutput is where progress messages are written. In JSON output mode,
+// stdout is reserved for JSON lines, for inspect and get for the printed
+// release, and with -summary-only for the summary, so messages go to stderr
+// instead.
+func logOutput() io.Writer {
+	if output == "json" || summaryOnly || flag.Arg(0) == "inspect" || flag.Arg(0) == "get" {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+func logf(format string, args ...any) {
+	fmt.Fprintf(logOutput(), format+"\n", args...)
+}
+
+// ErrorRecord describes a failed operation. With -json-errors, each error is
+// written to stderr as one ErrorRecord per line.
+type ErrorRecord struct {
+	Type      string `json:"type"`
+	Release   string `json:"release,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Version   int    `json:"version,omitempty"`
+	Operation string `json:"operation"`
+	Message   string `json:"message"`
+}
+
+// errorf prints an error message to stderr, so that it does not mix with
+// progress messages and results on stdout.
+func errorf(record ErrorRecord, format string, args ...any) {
+	record.Type = "error"
+	record.Message = fmt.Sprintf(format, args...)
+	if !jsonErrors {
+		fmt.Fprintln(os.Stderr, record.Message)
+		return
+	}
+	err := json.NewEncoder(os.Stderr).Encode(record)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write JSON error: %s\n", err)
+	}
+}
+
+// flagErrorf prints an error about invalid command line flags.
+func flagErrorf(format string, args ...any) {
+	errorf(ErrorRecord{Operation: "flags"}, format, args...)
+}
+
+// Log levels selected with -log-level. Results and errors are always
+// printed.
+const (
+	levelError = iota
+	levelWarning
+	levelInfo
+	levelDebug
+)
+
+var logLevels = map[string]int{
+	"error":   levelError,
+	"warning": levelWarning,
+	"info":    levelInfo,
+	"debug":   levelDebug,
+}
+
+// verbosity is parsed from -log-level and capped at levelWarning by -quiet.
+var verbosity = levelInfo
+
+// warnf prints a warning unless -log-level is error.
+func warnf(format string, args ...any) {
+	if verbosity >= levelWarning {
+		logf("warning: "+format, args...)
+	}
+}
+
+// infof prints a progress message unless -quiet is set or -log-level is
+// below info.
+func infof(format string, args ...any) {
+	if verbosity >= levelInfo {
+		logf(format, args...)
+	}
+}
+
+// debugf prints a message of the Helm SDK, or another message only useful
+// when debugging, if -log-level is debug.
+func debugf(format string, args ...any) {
+	if verbosity >= levelDebug {
+		logf(format, args...)
+	}
+}
+
+// writeJSON writes v as a single line to stdout. Stdout is unbuffered, so each
+// line reaches the consumer immediately.
+func writeJSON(v any) {
+	err := json.NewEncoder(os.Stdout).Encode(v)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write JSON output: %s\n", err)
+	}
+}
+
+// report records the outcome of a release and, in JSON output mode, emits it
+// right away. It is safe to call from concurrent migrations.
+func (m *Migrator) report(result *ReleaseResult, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err != nil {
+		result.Status = "failed"
+		result.Error = err.Error()
+	}
+	m.summary.add(result)
+	progress.finish(result)
+	m.writeResult(result)
+}
+
+// writeResult writes the result of a release to the output. The caller must
+// hold m.mutex.
+func (m *Migrator) writeResult(result *ReleaseResult) {
+	out := m.resultOutput(result.Namespace)
+	if output == "json" && !summaryOnly {
+		err := json.NewEncoder(out).Encode(result)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write JSON output: %s\n", err)
+		}
+	}
+	if resultTemplate != nil {
+		var buf bytes.Buffer
+		err := resultTemplate.Execute(&buf, result)
+		if err != nil {
+			errorf(ErrorRecord{Release: result.Release, Namespace: result.Namespace, Operation: "render-template"}, "failed to render -output-template for release %s: %s", result.Release, err)
+			return
+		}
+		fmt.Fprintln(out, strings.TrimSuffix(buf.String(), "\n"))
+	}
+}
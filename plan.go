@@ -0,0 +1,176 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// A plan is the JSON output of a run with -dry-run -output json. With
+// -plan-file, exactly the releases and versions it lists are migrated.
+
+// loadPlan reads the plan from -plan-file, keyed by planKey, along with the
+// keys in the order of the file.
+func loadPlan(path string) (map[string]ReleaseResult, []string, error) {
+	results, err := loadResults(path, "dry-run")
+	if err != nil {
+		return nil, nil, err
+	}
+	plan := make(map[string]ReleaseResult, len(results))
+	keys := make([]string, 0, len(results))
+	for _, result := range results {
+		key := planKey(result.Context, result.Namespace, result.Release)
+		if _, exists := plan[key]; !exists {
+			keys = append(keys, key)
+		}
+		plan[key] = result
+	}
+	return plan, keys, nil
+}
+
+func planKey(kubeContext string, namespace string, releaseName string) string {
+	return kubeContext + "/" + namespace + "/" + releaseName
+}
+
+// plannedVersions returns the versions a plan entry creates or deletes.
+func plannedVersions(entry ReleaseResult) []int {
+	versions := slices.Concat(entry.Create, entry.Delete)
+	slices.Sort(versions)
+	return slices.Compact(versions)
+}
+
+// executePlan migrates the releases of the -plan-file in this context and,
+// unless namespace is NamespaceAll, in this namespace. Before anything is
+// changed, all of them are checked against the current state of the cluster,
+// and nothing is done if it drifted from the plan, unless -force is set.
+func (m *Migrator) executePlan(namespace string) error {
+	var entries []ReleaseResult
+	for _, key := range planOrder {
+		entry := plan[key]
+		if entry.Context != m.kubeContext {
+			continue
+		}
+		if namespace != metav1.NamespaceAll && entry.Namespace != namespace {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	drifted := false
+	for _, entry := range entries {
+		problems, err := m.checkPlanEntry(entry)
+		if err != nil {
+			return err
+		}
+		for _, problem := range problems {
+			drifted = true
+			errorf(ErrorRecord{Release: entry.Release, Namespace: entry.Namespace, Operation: "plan"}, "release %s/%s drifted from the plan: %s", entry.Namespace, entry.Release, problem)
+		}
+	}
+	if drifted {
+		if !force {
+			return errors.New("not executing the plan because the cluster drifted from it, create a new plan or confirm with -force")
+		}
+		warnf("executing the plan although the cluster drifted from it")
+	}
+	if deleteLimited() {
+		deletes := 0
+		for _, entry := range entries {
+			deletes += len(plannedVersions(entry))
+		}
+		err := checkDeleteCount(deletes)
+		if err != nil {
+			return err
+		}
+	}
+	infof("executing plan for %d releases", len(entries))
+	for _, entry := range entries {
+		err := m.migrateRelease(entry.Release, entry.Namespace)
+		if err != nil {
+			errorf(ErrorRecord{Release: entry.Release, Namespace: entry.Namespace, Operation: "migrate"}, "%s", err)
+		}
+		err = m.checkMaxFailures()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkPlanEntry compares a plan entry with the current source and target
+// and describes each difference.
+func (m *Migrator) checkPlanEntry(entry ReleaseResult) ([]string, error) {
+	var problems []string
+	records, err := m.storedHistory(entry.Release, entry.Namespace)
+	if err != nil && !errors.Is(err, driver.ErrReleaseNotFound) {
+		return nil, err
+	}
+	inSource := make(map[int]bool, len(records))
+	for _, rls := range records {
+		inSource[rls.Version] = true
+	}
+	versions := plannedVersions(entry)
+	for _, version := range versions {
+		if !inSource[version] {
+			problems = append(problems, fmt.Sprintf("version %d is no longer in the source", version))
+		}
+	}
+	if len(versions) > 0 {
+		if latest := latestVersion(records); latest > versions[len(versions)-1] {
+			problems = append(problems, fmt.Sprintf("the source has the newer version %d", latest))
+		}
+	}
+
+	helmStorage, err := m.targetStorage(targetNamespaceFor(entry.Namespace))
+	if err != nil {
+		return nil, err
+	}
+	if entry.TargetDriver != "" && entry.TargetDriver != helmStorage.Name() {
+		problems = append(problems, fmt.Sprintf("the plan targets the %s driver, not %s", entry.TargetDriver, helmStorage.Name()))
+	}
+	for _, version := range entry.Create {
+		_, err := helmStorage.Get(entry.Release, version)
+		if err == nil {
+			problems = append(problems, fmt.Sprintf("version %d already exists in the target", version))
+		} else if !errors.Is(err, driver.ErrReleaseNotFound) {
+			return nil, err
+		}
+	}
+	return problems, nil
+}
+
+// plannedHistory returns the revisions of a release that the -plan-file
+// entry lists, regardless of -max and -since-version.
+func (m *Migrator) plannedHistory(entry ReleaseResult) ([]*release.Release, error) {
+	records, err := m.storedHistory(entry.Release, entry.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	versions := plannedVersions(entry)
+	return slices.DeleteFunc(records, func(rls *release.Release) bool {
+		_, found := slices.BinarySearch(versions, rls.Version)
+		return !found
+	}), nil
+}
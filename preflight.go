@@ -0,0 +1,149 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"helm.sh/helm/v3/pkg/storage/driver"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// PreflightCheck is the outcome of a single preflight check.
+type PreflightCheck struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail"`
+}
+
+// PreflightResult is the outcome of the preflight subprogram. It is written
+// as a single JSON object in JSON output mode.
+type PreflightResult struct {
+	Type   string           `json:"type"`
+	Checks []PreflightCheck `json:"checks"`
+	Passed bool             `json:"passed"`
+}
+
+// preflight checks that the cluster is reachable and that the caller may
+// perform every API call a migration of the given namespace needs. An empty
+// namespace checks permissions across all namespaces.
+func (m *Migrator) preflight(namespace string) error {
+	result := PreflightResult{Type: "preflight", Passed: true}
+	addCheck := func(name string, err error, detail string) {
+		check := PreflightCheck{Name: name, Passed: err == nil, Detail: detail}
+		if err != nil {
+			check.Detail = err.Error()
+			result.Passed = false
+		}
+		result.Checks = append(result.Checks, check)
+	}
+
+	if m.clientset == nil {
+		addCheck("connectivity", errors.New("no cluster is used with the memory driver"), "")
+	} else {
+		version, err := m.clientset.Discovery().ServerVersion()
+		if err != nil {
+			addCheck("connectivity", err, "")
+		} else {
+			addCheck("connectivity", nil, "Kubernetes "+version.GitVersion)
+			// only informational, since reading kube-system may be forbidden
+			// to operators who are only allowed to migrate single namespaces
+			fingerprint, err := m.clusterFingerprint()
+			if err != nil {
+				fingerprint = "unknown (" + err.Error() + ")"
+			}
+			addCheck("fingerprint", nil, "target cluster "+fingerprint+", see -expect-cluster")
+			sourceResource, err := driverResource(m.actionCfg.Releases.Name())
+			if err != nil {
+				addCheck("source-driver", err, "")
+			} else {
+				for _, verb := range []string{"list", "get", "delete"} {
+					addCheck("source-"+verb, checkAccess(m.clientset, namespace, verb, sourceResource), fmt.Sprintf("may %s %s", verb, sourceResource))
+				}
+			}
+			targetNS := targetNamespaceFor(namespace)
+			targetStorage, err := m.targetStorage(targetNS)
+			if err != nil {
+				addCheck("target-driver", err, "")
+			} else if targetResource, err := driverResource(targetStorage.Name()); err != nil {
+				addCheck("target-driver", err, "")
+			} else {
+				for _, verb := range []string{"list", "get", "create"} {
+					addCheck("target-"+verb, checkAccess(m.targetClientset, targetNS, verb, targetResource), fmt.Sprintf("may %s %s", verb, targetResource))
+				}
+			}
+		}
+	}
+
+	if output == "json" {
+		writeJSON(result)
+	} else {
+		for _, check := range result.Checks {
+			status := "ok"
+			if !check.Passed {
+				status = "FAILED"
+			}
+			logf("%-16s %-6s %s", check.Name, status, check.Detail)
+		}
+	}
+	if !result.Passed {
+		return errors.New("preflight checks failed")
+	}
+	return nil
+}
+
+// checkAccess asks the API server of a clientset whether the caller may
+// perform a verb on a core resource in a namespace.
+func checkAccess(clientset *kubernetes.Clientset, namespace string, verb string, resource string) error {
+	review := &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: namespace,
+				Verb:      verb,
+				Resource:  resource,
+			},
+		},
+	}
+	review, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(context.Background(), review, metav1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+	if !review.Status.Allowed {
+		return fmt.Errorf("not allowed to %s %s: %s", verb, resource, review.Status.Reason)
+	}
+	return nil
+}
+
+// driverResource returns the Kubernetes resource backing a Helm storage
+// driver.
+func driverResource(driverName string) (string, error) {
+	switch driverName {
+	case driver.ConfigMapsDriverName:
+		return "configmaps", nil
+	case driver.SecretsDriverName:
+		return "secrets", nil
+	default:
+		return "", fmt.Errorf("the %s driver is not backed by Kubernetes resources", driverName)
+	}
+}
@@ -0,0 +1,83 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	"k8s.io/client-go/rest"
+)
+
+// -read-only is enforced twice: the flag validation only admits subprograms
+// that do not change anything, and in case one of them has a bug, writes are
+// refused both by every storage driver and by the transport of every
+// Kubernetes client.
+
+var errReadOnly = errors.New("refusing to change anything with -read-only")
+
+// readOnlyConfig makes a rest config refuse all requests except reads with
+// -read-only.
+func readOnlyConfig(cfg *rest.Config) *rest.Config {
+	if readOnly {
+		cfg.Wrap(func(next http.RoundTripper) http.RoundTripper {
+			return &readOnlyTransport{next: next}
+		})
+	}
+	return cfg
+}
+
+type readOnlyTransport struct {
+	next http.RoundTripper
+}
+
+func (t *readOnlyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return t.next.RoundTrip(req)
+	}
+	// access reviews are created by preflight, but only ask a question
+	if req.Method == http.MethodPost && strings.HasPrefix(req.URL.Path, "/apis/authorization.k8s.io/") {
+		return t.next.RoundTrip(req)
+	}
+	resource, _ := apiResource(req.URL.Path)
+	return nil, fmt.Errorf("%s %s: %w", req.Method, resource, errReadOnly)
+}
+
+// readOnlyDriver refuses all writes to a storage driver with -read-only.
+type readOnlyDriver struct {
+	driver.Driver
+}
+
+func (d *readOnlyDriver) Create(key string, rls *release.Release) error {
+	return fmt.Errorf("create %s: %w", key, errReadOnly)
+}
+
+func (d *readOnlyDriver) Update(key string, rls *release.Release) error {
+	return fmt.Errorf("update %s: %w", key, errReadOnly)
+}
+
+func (d *readOnlyDriver) Delete(key string) (*release.Release, error) {
+	return nil, fmt.Errorf("delete %s: %w", key, errReadOnly)
+}
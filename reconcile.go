@@ -0,0 +1,73 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"fmt"
+
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+)
+
+// reconcileRelease makes the target converge to the source for -reconcile:
+// revisions missing in the target are created, then every revision is
+// verified and, unless -keep-source is set, deleted from the source once its
+// target copy is identical. Revisions whose target copy differs are never
+// touched. Running it again on a converged release changes nothing.
+func (m *Migrator) reconcileRelease(result *ReleaseResult, hist []*release.Release, helmStorage *storage.Storage, targetNS string) error {
+	releaseName := result.Release
+	failed := false
+	for _, rls := range orderedHistory(hist) {
+		state, err := targetState(helmStorage, rls)
+		if err == nil && state == "missing" {
+			err = m.createRelease(helmStorage, targetNS, result.Namespace, relocated(rls, targetNS))
+			if err == nil {
+				infof("created release %s version %d in target", releaseName, rls.Version)
+				state, err = targetState(helmStorage, rls)
+			}
+		}
+		if err == nil && state == "different" {
+			err = fmt.Errorf("version is different in target%s", mismatchDetail(helmStorage, rls))
+		} else if err == nil && state != "identical" {
+			err = fmt.Errorf("version is %s in target", state)
+		}
+		if err != nil {
+			failed = true
+			errorf(ErrorRecord{Release: releaseName, Namespace: targetNS, Version: rls.Version, Operation: "reconcile"}, "failed to reconcile release %s version %d: %s", releaseName, rls.Version, err)
+			result.FailedVersions = append(result.FailedVersions, rls.Version)
+			continue
+		}
+		if !keepSource {
+			err = m.deleteSource(helmStorage, releaseName, result.Namespace, rls.Version)
+			if err != nil {
+				failed = true
+				errorf(ErrorRecord{Release: releaseName, Namespace: result.Namespace, Version: rls.Version, Operation: "delete"}, "failed to delete release %s version %d: %s", releaseName, rls.Version, err)
+				result.FailedVersions = append(result.FailedVersions, rls.Version)
+				continue
+			}
+		}
+		result.Versions = append(result.Versions, rls.Version)
+	}
+	if failed {
+		return fmt.Errorf("failed to reconcile release %s", releaseName)
+	}
+	infof("reconciled release %s: %d revisions identical in target", releaseName, len(result.Versions))
+	return nil
+}
@@ -0,0 +1,81 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+)
+
+// relocateDriver returns the -to driver for the relocate subprogram, which
+// is the source driver from $HELM_DRIVER.
+func relocateDriver(helmDriver string) (string, error) {
+	switch helmDriver {
+	case "", "secret", "secrets":
+		return "secret", nil
+	case "configmap", "configmaps":
+		return "configmap", nil
+	case "memory":
+		return "memory", nil
+	default:
+		return "", fmt.Errorf("the relocate subprogram does not support the %s driver", helmDriver)
+	}
+}
+
+// relocateRelease moves the history of a release from -source-namespace to
+// -target-namespace within the same driver, using the same create-then-delete
+// steps as a migration between drivers. The namespace recorded in each
+// revision is rewritten, but the resources of the release are not moved.
+// Relocating onto an existing release of the same name is refused, since it
+// would mix the histories of two releases.
+func (m *Migrator) relocateRelease(releaseName string) error {
+	helmStorage, err := m.targetStorage(targetNamespace)
+	if err != nil {
+		return err
+	}
+	existing, err := helmStorage.History(releaseName)
+	if err != nil && !errors.Is(err, driver.ErrReleaseNotFound) {
+		return err
+	}
+	if len(existing) > 0 {
+		return fmt.Errorf("release %s already exists in namespace %s with %d revisions, uninstall or relocate it first", releaseName, targetNamespace, len(existing))
+	}
+	err = m.checkMaxDelete([]*release.Release{{Name: releaseName, Namespace: namespace}})
+	if err != nil {
+		return err
+	}
+	return m.migrateRelease(releaseName, namespace)
+}
+
+// relocated returns a copy of a revision with its namespace rewritten to the
+// target namespace, or the revision itself if it is already in there. Helm
+// expects the namespace recorded in a revision to be the one it is stored in,
+// both for relocate and for migrations with -target-namespace.
+func relocated(rls *release.Release, targetNS string) *release.Release {
+	if rls.Namespace == targetNS {
+		return rls
+	}
+	copied := *rls
+	copied.Namespace = targetNS
+	return &copied
+}
@@ -0,0 +1,202 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kblabels "k8s.io/apimachinery/pkg/labels"
+)
+
+// storedVersions maps "namespace/name" of each release to the versions of its
+// storage objects in one driver.
+type storedVersions map[string][]int
+
+// repairDuplicates finds releases that are stored both as ConfigMaps and as
+// Secrets, e.g. after an interrupted migration, and deletes one of the two
+// copies. The copy with the newer latest revision is kept; if both are at the
+// same revision, the copy in the -to driver is kept.
+func (m *Migrator) repairDuplicates(allNamespaces bool) error {
+	if m.clientset == nil {
+		return errors.New("repair-duplicates requires a cluster")
+	}
+	var targetDriver string
+	switch to {
+	case "configmap", "configmaps":
+		targetDriver = driver.ConfigMapsDriverName
+	case "secret", "secrets":
+		targetDriver = driver.SecretsDriverName
+	default:
+		return fmt.Errorf("repair-duplicates requires -to configmap or -to secret, got %q", to)
+	}
+	scope := namespace
+	if allNamespaces {
+		scope = metav1.NamespaceAll
+	}
+	// the -to driver was written by Helm or by us, the other one may use
+	// legacy label keys
+	cmKeys, secretKeys := sourceLabelKeys, standardLabelKeys
+	if targetDriver == driver.ConfigMapsDriverName {
+		cmKeys, secretKeys = standardLabelKeys, sourceLabelKeys
+	}
+	cmOpts := metav1.ListOptions{LabelSelector: kblabels.Set{cmKeys.Owner: owner}.String()}
+	configMaps, err := m.clientset.CoreV1().ConfigMaps(scope).List(context.Background(), cmOpts)
+	if err != nil {
+		return err
+	}
+	secretOpts := metav1.ListOptions{LabelSelector: kblabels.Set{secretKeys.Owner: owner}.String()}
+	secrets, err := m.clientset.CoreV1().Secrets(scope).List(context.Background(), secretOpts)
+	if err != nil {
+		return err
+	}
+	inConfigMaps := make(storedVersions)
+	for _, cm := range configMaps.Items {
+		inConfigMaps.add(cm.Namespace, cm.Labels, cmKeys)
+	}
+	inSecrets := make(storedVersions)
+	for _, secret := range secrets.Items {
+		inSecrets.add(secret.Namespace, secret.Labels, secretKeys)
+	}
+
+	var keys []string
+	for key := range inConfigMaps {
+		if inSecrets[key] != nil {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	failed := false
+	for _, key := range keys {
+		err := m.repairDuplicate(key, inConfigMaps[key], inSecrets[key], targetDriver)
+		if err != nil {
+			releaseNamespace, releaseName, _ := strings.Cut(key, "/")
+			errorf(ErrorRecord{Release: releaseName, Namespace: releaseNamespace, Operation: "repair"}, "%s", err)
+			failed = true
+		}
+	}
+	if failed {
+		return errors.New("some duplicates could not be repaired")
+	}
+	return nil
+}
+
+func (s storedVersions) add(namespace string, labels map[string]string, keys labelKeys) {
+	version, err := strconv.Atoi(labels[keys.Version])
+	if err != nil || labels[keys.Name] == "" {
+		return
+	}
+	key := namespace + "/" + labels[keys.Name]
+	s[key] = append(s[key], version)
+}
+
+// repairDuplicate deletes the stale copy of a single release. Revisions are
+// only deleted from the other driver if the kept copy has them with the same
+// content. Revisions missing in the kept copy are copied to it first, while
+// revisions whose content differs are left in both drivers and fail the
+// repair, as it cannot tell which copy is right.
+func (m *Migrator) repairDuplicate(key string, cmVersions []int, secretVersions []int, targetDriver string) (err error) {
+	releaseNamespace, releaseName, _ := strings.Cut(key, "/")
+	result := &ReleaseResult{Type: "release", Context: m.kubeContext, Release: releaseName, Namespace: releaseNamespace, Status: "repaired"}
+	defer func() { m.report(result, err) }()
+
+	cmLatest, secretLatest := slices.Max(cmVersions), slices.Max(secretVersions)
+	keep := targetDriver
+	switch {
+	case cmLatest > secretLatest:
+		keep = driver.ConfigMapsDriverName
+	case secretLatest > cmLatest:
+		keep = driver.SecretsDriverName
+	}
+	remove, versions := driver.SecretsDriverName, secretVersions
+	if keep == driver.SecretsDriverName {
+		remove, versions = driver.ConfigMapsDriverName, cmVersions
+	}
+	slices.Sort(versions)
+	kept := storage.Init(newKubeDriver(m.clientset, keep, releaseNamespace))
+	removed := newKubeDriver(m.clientset, remove, releaseNamespace)
+
+	var missing, different []int
+	for _, version := range versions {
+		name := releaseKey(releaseName, version)
+		duplicate, err := removed.Get(name)
+		if err != nil {
+			return fmt.Errorf("failed to read duplicate %s %s/%s: %w", remove, releaseNamespace, name, err)
+		}
+		if remove != targetDriver {
+			normalizeLabels(duplicate)
+		}
+		original, err := kept.Get(releaseName, version)
+		switch {
+		case errors.Is(err, driver.ErrReleaseNotFound):
+			missing = append(missing, version)
+			if dryRun {
+				continue
+			}
+			err = kept.Create(duplicate)
+			if err != nil {
+				return fmt.Errorf("failed to copy version %d from %s to %s: %w", version, remove, keep, err)
+			}
+			logf("release %s version %d was only stored in %s, copied it to %s", key, version, remove, keep)
+		case err != nil:
+			return fmt.Errorf("failed to read version %d from %s: %w", version, keep, err)
+		case !sameContent(duplicate, original):
+			different = append(different, version)
+			result.warn("version %d differs between %s and %s, keeping both", version, remove, keep)
+			continue
+		}
+		result.PrunedVersions = append(result.PrunedVersions, version)
+		if dryRun {
+			continue
+		}
+		_, err = removed.Delete(name)
+		if err != nil {
+			return fmt.Errorf("failed to delete duplicate %s %s/%s: %w", remove, releaseNamespace, name, err)
+		}
+	}
+	if dryRun {
+		result.Status = "dry-run"
+		logf("release %s is stored as ConfigMaps up to version %d and as Secrets up to version %d, would keep %s, copy %d revisions to it and delete %d revisions from %s", key, cmLatest, secretLatest, keep, len(missing), len(result.PrunedVersions), remove)
+	} else {
+		logf("release %s was stored as ConfigMaps up to version %d and as Secrets up to version %d, kept %s, copied %d revisions to it and deleted %d revisions from %s", key, cmLatest, secretLatest, keep, len(missing), len(result.PrunedVersions), remove)
+	}
+	if len(different) > 0 {
+		result.FailedVersions = different
+		return fmt.Errorf("versions %v of release %s differ between %s and %s, resolve them manually", different, key, remove, keep)
+	}
+	return nil
+}
+
+// sameContent reports whether two revisions of a release are identical apart
+// from their labels, which the drivers derive from the storage object and
+// which may use legacy label keys in one of them.
+func sameContent(a, b *release.Release) bool {
+	aCopy, bCopy := *a, *b
+	aCopy.Labels, bCopy.Labels = nil, nil
+	return sameRelease(&aCopy, &bCopy)
+}
@@ -0,0 +1,130 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+)
+
+// ReportRow describes a release in the output of the report subprogram.
+type ReportRow struct {
+	Type      string `json:"type"`
+	Release   string `json:"release"`
+	Namespace string `json:"namespace"`
+	Driver    string `json:"driver"`
+	Revisions int    `json:"revisions"`
+	Status    string `json:"status"`
+	Chart     string `json:"chart"`
+	InTarget  string `json:"in_target"`
+}
+
+// printReport prints an overview of the releases in -namespace, or in all
+// namespaces, without changing anything. InTarget tells whether all, some or
+// none of the stored revisions already exist in the -to driver.
+func (m *Migrator) printReport(allNamespaces bool) error {
+	releases, err := m.listReleases(allNamespaces)
+	if err != nil {
+		return err
+	}
+	var rows []ReportRow
+	for _, release := range releases {
+		if !allNamespaces && release.Namespace != namespace {
+			continue
+		}
+		hist, err := m.storedHistory(release.Name, release.Namespace)
+		if err != nil {
+			return err
+		}
+		row := ReportRow{
+			Type:      "report",
+			Release:   release.Name,
+			Namespace: release.Namespace,
+			Driver:    m.actionCfg.Releases.Name(),
+			Revisions: len(hist),
+			Status:    releaseStatus(release).String(),
+			Chart:     "unknown",
+			InTarget:  "-",
+		}
+		metadata, err := chartMetadata(release)
+		if err == nil {
+			row.Chart = metadata.Name + "-" + metadata.Version
+		}
+		if to != "" {
+			row.InTarget, err = m.presenceInTarget(release.Namespace, release.Name, hist)
+			if err != nil {
+				return err
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	if output == "json" {
+		for _, row := range rows {
+			writeJSON(row)
+		}
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tNAMESPACE\tDRIVER\tREVISIONS\tSTATUS\tCHART\tIN TARGET")
+	for _, row := range rows {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n", row.Release, row.Namespace, row.Driver, row.Revisions, row.Status, row.Chart, row.InTarget)
+	}
+	return w.Flush()
+}
+
+// presenceInTarget returns "yes", "partial" or "no" depending on how many of
+// the source revisions of a release exist in the target driver.
+func (m *Migrator) presenceInTarget(namespace string, releaseName string, hist []*release.Release) (string, error) {
+	helmStorage, err := m.targetStorage(targetNamespaceFor(namespace))
+	if err != nil {
+		return "", err
+	}
+	existing, err := helmStorage.History(releaseName)
+	if errors.Is(err, driver.ErrReleaseNotFound) {
+		return "no", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	inTarget := make(map[int]bool, len(existing))
+	for _, release := range existing {
+		inTarget[release.Version] = true
+	}
+	present := 0
+	for _, release := range hist {
+		if inTarget[release.Version] {
+			present++
+		}
+	}
+	switch present {
+	case len(hist):
+		return "yes", nil
+	case 0:
+		return "no", nil
+	default:
+		return "partial", nil
+	}
+}
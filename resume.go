@@ -0,0 +1,50 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"fmt"
+
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+)
+
+// resumeConflict decides for -resume-on-conflict whether a revision that
+// already exists in the target was created by an earlier, interrupted run.
+// Unlike -force-delete, which trusts any existing copy, the copy must be
+// identical to the revision that would have been created, so that the source
+// revision can be deleted as if it had just been migrated. Otherwise the
+// conflict is returned as an error.
+func resumeConflict(helmStorage *storage.Storage, rls *release.Release) error {
+	state, err := targetState(helmStorage, rls)
+	if err != nil {
+		return fmt.Errorf("%w, and reading it failed: %w", driver.ErrReleaseExists, err)
+	}
+	switch state {
+	case "identical":
+		infof("release %s version %d already exists in target with the same content, resuming its migration", rls.Name, rls.Version)
+		return nil
+	case "different":
+		return fmt.Errorf("%w with a different content%s", driver.ErrReleaseExists, mismatchDetail(helmStorage, rls))
+	default:
+		return fmt.Errorf("%w, but is %s when reading it back", driver.ErrReleaseExists, state)
+	}
+}
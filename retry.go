@@ -0,0 +1,121 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"helm.sh/helm/v3/pkg/release"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// loadFailedReleases reads a report written by a previous run with -output
+// json and returns the results of the releases that failed.
+func loadFailedReleases(path string) ([]ReleaseResult, error) {
+	return loadResults(path, "failed")
+}
+
+// loadResults reads a report written by a previous run with -output json and
+// returns the results of the releases with the given status. Other lines,
+// like the summary, are ignored.
+func loadResults(path string, status string) ([]ReleaseResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var results []ReleaseResult
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var result ReleaseResult
+		err := json.Unmarshal(scanner.Bytes(), &result)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if result.Type == "release" && result.Status == status {
+			results = append(results, result)
+		}
+	}
+	return results, scanner.Err()
+}
+
+// retryFailed migrates the releases from -retry-from-report that failed in
+// this context and, unless namespace is NamespaceAll, in this namespace.
+func (m *Migrator) retryFailed(namespace string) error {
+	var releases []*release.Release
+	for _, result := range failedReleases {
+		if result.Context != m.kubeContext {
+			continue
+		}
+		if namespace != metav1.NamespaceAll && result.Namespace != namespace {
+			continue
+		}
+		if matchesAny(excludeMatchers, result.Release) {
+			logf("excluding release %s/%s", result.Namespace, result.Release)
+			continue
+		}
+		releases = append(releases, &release.Release{Name: result.Release, Namespace: result.Namespace})
+	}
+	err := m.checkMaxDelete(releases)
+	if err != nil {
+		return err
+	}
+	for _, rls := range releases {
+		err := m.migrateRelease(rls.Name, rls.Namespace)
+		if err != nil {
+			errorf(ErrorRecord{Release: rls.Name, Namespace: rls.Namespace, Operation: "migrate"}, "%s", err)
+		}
+	}
+	return nil
+}
+
+var (
+	// retriesUsed counts the retries of the whole run against -retry-budget
+	retriesUsed     atomic.Int64
+	budgetExhausted sync.Once
+)
+
+// takeRetry reports whether another retry is allowed by -retry-budget, and
+// counts it if so. The first time the budget is exhausted, a warning is
+// printed so that operators know why later failures were not retried.
+func takeRetry() bool {
+	if retryBudget == 0 {
+		return true
+	}
+	if retriesUsed.Add(1) <= int64(retryBudget) {
+		return true
+	}
+	budgetExhausted.Do(func() {
+		warnf("the -retry-budget of %d retries is exhausted, failures are no longer retried", retryBudget)
+	})
+	return false
+}
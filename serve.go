@@ -0,0 +1,189 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// progressTracker collects the state of a run for the -serve endpoints. A nil
+// tracker ignores all updates, so callers do not need to check for -serve.
+type progressTracker struct {
+	mutex   sync.Mutex
+	summary Summary
+	total   int
+	current map[string]bool
+	results []ReleaseResult
+}
+
+// Progress is served on /progress and printed by -report-interval. Total
+// counts the releases found by listing namespaces so far, and is 0 when
+// releases are not listed, e.g. for the release subprogram.
+type Progress struct {
+	Summary
+	Total   int      `json:"total"`
+	Current []string `json:"current"`
+}
+
+var progress *progressTracker
+
+// newProgressTracker returns the tracker used with -serve and
+// -report-interval.
+func newProgressTracker() *progressTracker {
+	return &progressTracker{
+		summary: Summary{Type: "progress"},
+		current: make(map[string]bool),
+	}
+}
+
+func (p *progressTracker) addTotal(releases int) {
+	if p == nil {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.total += releases
+}
+
+func (p *progressTracker) start(namespace string, releaseName string) {
+	if p == nil {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.current[namespace+"/"+releaseName] = true
+}
+
+func (p *progressTracker) finish(result *ReleaseResult) {
+	if p == nil {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.current, result.Namespace+"/"+result.Release)
+	p.summary.add(result)
+	p.results = append(p.results, *result)
+}
+
+// snapshot returns the current progress with the releases in progress
+// sorted by namespace and name.
+func (p *progressTracker) snapshot() Progress {
+	p.mutex.Lock()
+	body := Progress{Summary: p.summary, Total: p.total, Current: []string{}}
+	for key := range p.current {
+		body.Current = append(body.Current, key)
+	}
+	p.mutex.Unlock()
+	slices.Sort(body.Current)
+	return body
+}
+
+// serveProgress starts an HTTP server on addr that exposes the progress of
+// the run on /progress and the results of all finished releases on
+// /results. The returned function shuts the server down.
+func serveProgress(addr string) (func(), error) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /progress", func(w http.ResponseWriter, r *http.Request) {
+		respondJSON(w, progress.snapshot())
+	})
+	mux.HandleFunc("GET /results", func(w http.ResponseWriter, r *http.Request) {
+		progress.mutex.Lock()
+		results := slices.Clone(progress.results)
+		progress.mutex.Unlock()
+		if results == nil {
+			results = []ReleaseResult{}
+		}
+		respondJSON(w, results)
+	})
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logf("progress server failed: %s", err)
+		}
+	}()
+	infof("serving progress on http://%s/progress", listener.Addr())
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := server.Shutdown(ctx)
+		if err != nil {
+			logf("failed to shut down progress server: %s", err)
+		}
+	}, nil
+}
+
+func respondJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		logf("failed to write progress response: %s", err)
+	}
+}
+
+// reportProgress prints the progress of the run to stderr every interval,
+// even with -quiet, so that monitoring can detect a stalled run. The returned
+// function stops it.
+func reportProgress(interval time.Duration) func() {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				p := progress.snapshot()
+				processed := fmt.Sprintf("%d", p.Releases)
+				if p.Total > 0 {
+					processed = fmt.Sprintf("%d/%d", p.Releases, p.Total)
+				}
+				var namespaces []string
+				for _, key := range p.Current {
+					namespace, _, _ := strings.Cut(key, "/")
+					if !slices.Contains(namespaces, namespace) {
+						namespaces = append(namespaces, namespace)
+					}
+				}
+				fmt.Fprintf(os.Stderr, "progress: %s releases processed, %s, in progress in namespaces: %s\n",
+					processed, p.outcomes(), strings.Join(namespaces, ", "))
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
@@ -0,0 +1,103 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// timestampLabels are the storage labels in which the ConfigMaps and Secrets
+// drivers record when they created and last updated a storage object. The
+// Helm drivers' Create sets createdAt to the current time, but then applies
+// the labels of the revision on top: revisions read with a label query, as on
+// the default path, thus keep both timestamp labels of their source object,
+// while revisions read one by one, e.g. with -chunk-history, get a fresh
+// createdAt and no modifiedAt. Objects written directly, see writeRelease,
+// always get a fresh createdAt. -preserve-timestamps restores both from the
+// source in all cases. The deployment timestamps of a revision
+// (first_deployed, last_deployed and deleted) are part of the release payload
+// instead, and are always migrated as is.
+var timestampLabels = []string{"createdAt", "modifiedAt"}
+
+// restoreTimestamps copies the timestamp labels of the source storage object
+// of a revision in sourceNS to its newly created copy in targetNS. Failures
+// are logged, but do not fail the migration of the revision.
+func (m *Migrator) restoreTimestamps(targetDriver string, targetNS string, sourceNS string, rls *release.Release) {
+	key := releaseKey(rls.Name, rls.Version)
+	labels, err := m.sourceObjectLabels(sourceNS, key)
+	if err != nil {
+		errorf(ErrorRecord{Release: rls.Name, Namespace: sourceNS, Version: rls.Version, Operation: "preserve-timestamps"}, "failed to read timestamps of release %s version %d: %s", rls.Name, rls.Version, err)
+		return
+	}
+	timestamps := make(map[string]string)
+	for _, label := range timestampLabels {
+		if value, ok := labels[label]; ok {
+			timestamps[label] = value
+		}
+	}
+	if len(timestamps) == 0 {
+		return
+	}
+	patch, err := json.Marshal(map[string]any{"metadata": map[string]any{"labels": timestamps}})
+	if err != nil {
+		return
+	}
+	targetKey := targetStoragePrefix + key
+	switch targetDriver {
+	case driver.ConfigMapsDriverName:
+		_, err = m.targetClientset.CoreV1().ConfigMaps(targetNS).Patch(context.Background(), targetKey, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: fieldManager})
+	case driver.SecretsDriverName:
+		_, err = m.targetClientset.CoreV1().Secrets(targetNS).Patch(context.Background(), targetKey, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: fieldManager})
+	default:
+		return
+	}
+	if err != nil {
+		errorf(ErrorRecord{Release: rls.Name, Namespace: targetNS, Version: rls.Version, Operation: "preserve-timestamps"}, "failed to restore timestamps of release %s version %d: %s", rls.Name, rls.Version, err)
+	}
+}
+
+// sourceObjectLabels returns the labels of a storage object in the source.
+// The Helm drivers strip the timestamp labels when decoding a release, so
+// they are read from the object itself.
+func (m *Migrator) sourceObjectLabels(namespace string, key string) (map[string]string, error) {
+	switch m.actionCfg.Releases.Name() {
+	case driver.ConfigMapsDriverName:
+		cm, err := m.clientset.CoreV1().ConfigMaps(namespace).Get(context.Background(), key, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return cm.Labels, nil
+	case driver.SecretsDriverName:
+		secret, err := m.clientset.CoreV1().Secrets(namespace).Get(context.Background(), key, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return secret.Labels, nil
+	default:
+		return nil, fmt.Errorf("the %s driver has no storage labels", m.actionCfg.Releases.Name())
+	}
+}
@@ -0,0 +1,76 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"testing"
+
+	"helm.sh/helm/v3/pkg/release"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestPreserveTimestampsWithTargetNamespace(t *testing.T) {
+	tests := []struct {
+		name         string
+		keepSource   bool
+		chunkHistory int
+	}{
+		{name: "migrate"},
+		{name: "copy", keepSource: true},
+		// revisions read one by one carry no timestamp labels
+		{name: "migrate in chunks", chunkHistory: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFlags(t)
+			oldTarget, oldPreserve, oldKeep, oldChunk := targetNamespace, preserveTimestamps, keepSource, chunkHistory
+			defer func() {
+				targetNamespace, preserveTimestamps, keepSource, chunkHistory = oldTarget, oldPreserve, oldKeep, oldChunk
+			}()
+			to, namespace, targetNamespace, preserveTimestamps = "configmap", "a", "b", true
+			keepSource, chunkHistory = tt.keepSource, tt.chunkHistory
+
+			api := &fakeConfigMaps{configMaps: make(map[string]*corev1.ConfigMap)}
+			source := releaseConfigMap(t, testRelease("app", "a", 1, release.StatusDeployed))
+			source.Labels["createdAt"] = "1600000000"
+			source.Labels["modifiedAt"] = "1600000100"
+			api.put(source)
+			m := fakeMigrator(t, api)
+
+			err := m.migrateRelease("app", "a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			target := api.configMaps[configMapKey("b", source.Name)]
+			if target == nil {
+				t.Fatal("release was not created in the target namespace")
+			}
+			for _, label := range timestampLabels {
+				if target.Labels[label] != source.Labels[label] {
+					t.Errorf("target has %s=%s, want %s from the source", label, target.Labels[label], source.Labels[label])
+				}
+			}
+			_, inSource := api.configMaps[configMapKey("a", source.Name)]
+			if inSource != tt.keepSource {
+				t.Errorf("source object exists: %t, want %t", inSource, tt.keepSource)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/rest"
+)
+
+// traceConfig makes a rest config log every API call with -trace-api.
+func traceConfig(cfg *rest.Config) *rest.Config {
+	if traceAPI {
+		cfg.Wrap(func(next http.RoundTripper) http.RoundTripper {
+			return &tracingTransport{next: next}
+		})
+	}
+	return cfg
+}
+
+// tracingTransport logs the method, resource, namespace, status and latency
+// of each request at debug level. Neither request nor response bodies are
+// logged, since they hold the release payloads of Secrets.
+type tracingTransport struct {
+	next http.RoundTripper
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	resource, namespace := apiResource(req.URL.Path)
+	if namespace != "" {
+		resource += " in namespace " + namespace
+	}
+	if err != nil {
+		debugf("API %s %s: %s after %s", req.Method, resource, err, time.Since(start).Round(time.Millisecond))
+		return resp, err
+	}
+	debugf("API %s %s: %s in %s", req.Method, resource, resp.Status, time.Since(start).Round(time.Millisecond))
+	return resp, nil
+}
+
+// apiResource splits the path of a Kubernetes API request like
+// /api/v1/namespaces/default/secrets/sh.helm.release.v1.foo.v1 into the
+// resource with its name, if any, and the namespace. Other paths, like those
+// of the discovery API, are returned unchanged.
+func apiResource(path string) (resource string, namespace string) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case len(segments) > 2 && segments[0] == "api":
+		segments = segments[2:]
+	case len(segments) > 3 && segments[0] == "apis":
+		segments = segments[3:]
+	default:
+		return path, ""
+	}
+	if len(segments) > 2 && segments[0] == "namespaces" {
+		namespace, segments = segments[1], segments[2:]
+	}
+	return strings.Join(segments, "/"), namespace
+}
@@ -0,0 +1,107 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+)
+
+// VerifyResult is the outcome of verifying a single release.
+type VerifyResult struct {
+	Type      string `json:"type"`
+	Release   string `json:"release"`
+	Namespace string `json:"namespace"`
+	Verified  []int  `json:"verified,omitempty"`
+	Missing   []int  `json:"missing,omitempty"`
+	Different []int  `json:"different,omitempty"`
+}
+
+// verifyReleases checks that every source revision of the releases in
+// -namespace, or in all namespaces, has an identical copy in the target
+// driver. Nothing is changed.
+func (m *Migrator) verifyReleases(allNamespaces bool) error {
+	releases, err := m.listReleases(allNamespaces)
+	if err != nil {
+		return err
+	}
+	discrepancies := 0
+	for _, rls := range releases {
+		if !allNamespaces && rls.Namespace != namespace {
+			continue
+		}
+		hist, err := m.storedHistory(rls.Name, rls.Namespace)
+		if err != nil {
+			return err
+		}
+		helmStorage, err := m.targetStorage(targetNamespaceFor(rls.Namespace))
+		if err != nil {
+			return err
+		}
+		result := VerifyResult{Type: "verify", Release: rls.Name, Namespace: rls.Namespace}
+		for _, revision := range hist {
+			state, err := targetState(helmStorage, revision)
+			if err != nil {
+				return err
+			}
+			switch state {
+			case "identical":
+				result.Verified = append(result.Verified, revision.Version)
+			case "missing":
+				result.Missing = append(result.Missing, revision.Version)
+				logf("release %s/%s version %d is missing in target", rls.Namespace, rls.Name, revision.Version)
+			case "different":
+				result.Different = append(result.Different, revision.Version)
+				logf("release %s/%s version %d differs in target%s", rls.Namespace, rls.Name, revision.Version, mismatchDetail(helmStorage, revision))
+			}
+		}
+		discrepancies += len(result.Missing) + len(result.Different)
+		if output == "json" {
+			writeJSON(result)
+		} else if len(result.Missing)+len(result.Different) == 0 {
+			infof("release %s/%s: all %d revisions verified", rls.Namespace, rls.Name, len(result.Verified))
+		}
+	}
+	if discrepancies > 0 {
+		return fmt.Errorf("verification failed: %d revisions are missing or differ in target", discrepancies)
+	}
+	return nil
+}
+
+// targetState compares a source revision with its copy in the target driver
+// and returns "identical", "missing" or "different". The copy is expected to
+// record the namespace it is stored in, see relocated.
+func targetState(helmStorage *storage.Storage, rls *release.Release) (string, error) {
+	migrated, err := helmStorage.Get(rls.Name, rls.Version)
+	if errors.Is(err, driver.ErrReleaseNotFound) {
+		return "missing", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if !sameRelease(relocated(rls, migrated.Namespace), migrated) {
+		return "different", nil
+	}
+	return "identical", nil
+}
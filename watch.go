@@ -0,0 +1,101 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"helm.sh/helm/v3/pkg/storage/driver"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kblabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// watchReleases migrates releases as their storage objects are created or
+// updated in the source driver, until the process is interrupted. Existing
+// releases are migrated when the watch starts. An empty namespace watches all
+// namespaces.
+func (m *Migrator) watchReleases(namespace string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	selector := kblabels.Set{sourceLabelKeys.Owner: owner}.AsSelector().String()
+	factory := informers.NewSharedInformerFactoryWithOptions(m.clientset, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = selector
+		}),
+	)
+	var informer cache.SharedIndexInformer
+	switch m.actionCfg.Releases.Name() {
+	case driver.ConfigMapsDriverName:
+		informer = factory.Core().V1().ConfigMaps().Informer()
+	case driver.SecretsDriverName:
+		informer = factory.Core().V1().Secrets().Informer()
+	default:
+		return fmt.Errorf("-watch is not supported for the %s driver", m.actionCfg.Releases.Name())
+	}
+
+	queue := workqueue.NewTyped[cache.ObjectName]()
+	enqueue := func(obj any) {
+		object, err := meta.Accessor(obj)
+		if err != nil {
+			return
+		}
+		releaseName := object.GetLabels()[sourceLabelKeys.Name]
+		if releaseName == "" || matchesAny(excludeMatchers, releaseName) {
+			return
+		}
+		queue.Add(cache.ObjectName{Namespace: object.GetNamespace(), Name: releaseName})
+	}
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueue,
+		UpdateFunc: func(_, obj any) { enqueue(obj) },
+	})
+	if err != nil {
+		return err
+	}
+	factory.Start(ctx.Done())
+	go func() {
+		<-ctx.Done()
+		queue.ShutDown()
+	}()
+
+	infof("watching for new releases, interrupt to stop")
+	for {
+		key, shutdown := queue.Get()
+		if shutdown {
+			factory.Shutdown()
+			return nil
+		}
+		err := m.migrateRelease(key.Name, key.Namespace)
+		if err != nil {
+			errorf(ErrorRecord{Release: key.Name, Namespace: key.Namespace, Operation: "migrate"}, "%s", err)
+		}
+		queue.Done(key)
+	}
+}
@@ -0,0 +1,91 @@
+/*******************************************************************************
+*
+* Copyright 2024 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const webhookTimeout = 10 * time.Second
+
+// headerList is a flag value for HTTP headers in the form "Name: value". It
+// can be given multiple times, but unlike stringList is not split at commas,
+// which are common in header values.
+type headerList []string
+
+func (l *headerList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *headerList) Set(value string) error {
+	name, _, ok := strings.Cut(value, ":")
+	if !ok || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("header %q is not in the form \"Name: value\"", value)
+	}
+	*l = append(*l, value)
+	return nil
+}
+
+// WebhookPayload is posted to -webhook-url when the run completes.
+type WebhookPayload struct {
+	Summary
+	Contexts        []string `json:"contexts"`
+	DurationSeconds float64  `json:"duration_seconds"`
+	Success         bool     `json:"success"`
+}
+
+// postWebhook sends the payload to -webhook-url with the -webhook-header
+// headers. Failures are logged, but do not affect the outcome of the run.
+func postWebhook(payload WebhookPayload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		errorf(ErrorRecord{Operation: "webhook"}, "failed to encode webhook payload: %s", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		errorf(ErrorRecord{Operation: "webhook"}, "invalid -webhook-url: %s", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for _, header := range webhookHeaders {
+		name, value, _ := strings.Cut(header, ":")
+		req.Header.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		errorf(ErrorRecord{Operation: "webhook"}, "failed to post to -webhook-url: %s", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		errorf(ErrorRecord{Operation: "webhook"}, "-webhook-url responded with %s", resp.Status)
+		return
+	}
+	infof("posted summary to -webhook-url")
+}